@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Allowlist is a set of SHA-256 digests of known-good files.
+// It is filled once at startup and only read by the workers.
+type Allowlist map[string]struct{}
+
+// loadAllowlist reads the digests from a file with one hex digest
+// per line. The rest of the line after the digest is ignored,
+// so the output of sha256sum can be used as is:
+//
+//	# WordPress 6.4 core
+//	9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08  wp-login.php
+func loadAllowlist(path string) (Allowlist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	al := make(Allowlist)
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		h := strings.ToLower(strings.Fields(line)[0])
+		if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid sha256 digest %q", path, n, h)
+		}
+		al[h] = struct{}{}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return al, nil
+}
+
+// Contains computes the digest of the content from r
+// and reports whether it is in the allowlist.
+func (al Allowlist) Contains(r io.Reader) (bool, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return false, err
+	}
+	_, ok := al[hex.EncodeToString(h.Sum(nil))]
+	return ok, nil
+}
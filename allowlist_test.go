@@ -0,0 +1,68 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func TestAllowlistArchive(t *testing.T) {
+	db, err := scanner.LoadDatabase(strings.NewReader(`<?xml version="1.0"?>
+<database>
+<signature id="1" title="eval-post" sever="c">eval\s*\(\s*\$_POST</signature>
+</database>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nr, _ := db.CompileNormalizers()
+	scn := scanner.NewScanner(db, nr)
+
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	w, _ := zw.Create("shell.php")
+	w.Write([]byte("<?php eval($_POST['c']);"))
+	zw.Close()
+	path := filepath.Join(t.TempDir(), "site.zip")
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(b.Bytes())
+
+	savedAllow, savedArchives, savedReporter, savedFs := allowlist, SCANARCHIVES, reporter, fsys
+	defer func() { allowlist, SCANARCHIVES, reporter, fsys = savedAllow, savedArchives, savedReporter, savedFs }()
+	SCANARCHIVES, fsys = true, scanner.LocalFS{}
+
+	tests := []struct {
+		allow  Allowlist
+		reason Reason
+		n      int
+	}{
+		{Allowlist{hex.EncodeToString(sum[:]): {}}, SKIP_ALLOWED, 0},
+		{Allowlist{strings.Repeat("0", 64): {}}, "", 1},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		allowlist, reporter = tt.allow, NewReporter(&out, FORMAT_TEXT, true)
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms, reason := scanFile(scn, f, path)
+		f.Close()
+		if reason != tt.reason || len(ms) != tt.n {
+			t.Errorf("allowlist %v: reason %q, %d matches, want %q, %d", tt.allow, reason, len(ms), tt.reason, tt.n)
+		}
+		if tt.n == 0 && out.Len() > 0 {
+			t.Errorf("allowlisted archive reported:\n%s", out.String())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// ARCHIVE_MAXBYTES caps the total decompressed size of a single archive,
+// so a zip bomb cannot keep a worker busy forever.
+const ARCHIVE_MAXBYTES = 256 * 1024 * 1024 // 256M
+
+// ARCHIVE_SEP separates the archive path and the entry name
+// in the reported path of an entry.
+const ARCHIVE_SEP = "::"
+
+var errArchiveTooLarge = fmt.Errorf("archive expands to more than %dM", ARCHIVE_MAXBYTES>>(10*2))
+
+// isArchive reports whether the file name has the extension
+// of a supported archive.
+func isArchive(path string) bool {
+	p := strings.ToLower(path)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(p, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanArchive scans the entries of a zip, tar or tar.gz archive
+// in memory. The matches are reported as "archive::entry".
+// Returns the reported matches of all entries.
+func scanArchive(scn *scanner.Scanner, f scanner.File, name string) ([]scanner.Match, Reason) {
+	budget := &budgetReader{n: ARCHIVE_MAXBYTES}
+
+	var found []scanner.Match
+	scanEntry := func(entry string, r io.Reader) Reason {
+		if !scn.AcceptName(entry) {
+			return SKIP_FILTERED
+		}
+		budget.r = r
+		br := bufio.NewReaderSize(budget, 512)
+		head, _ := br.Peek(scanner.MIME_SNIFF_LEN)
+		if len(head) == 0 {
+			return SKIP_EMPTY
+		}
+		if scn.CheckContentType() && !scn.AcceptContentType(scanner.DetectContentType(head)) {
+			return SKIP_BINARY
+		}
+		ms, reason := scanReader(scn, br, name+ARCHIVE_SEP+entry)
+		found = append(found, ms...)
+		return reason
+	}
+
+	p := strings.ToLower(name)
+	if strings.HasSuffix(p, ".zip") {
+		ra, ok := f.(io.ReaderAt)
+		if !ok {
+			warning(ERR_READ, "random access is not supported", name)
+			return found, ERR_READ
+		}
+		st, err := f.Stat()
+		if err != nil {
+			reason := errReason(err, ERR_READ)
+			warning(reason, err, name)
+			return found, reason
+		}
+		zr, err := zip.NewReader(ra, st.Size())
+		if err != nil {
+			warning(ERR_READ, err, name)
+			return found, ERR_READ
+		}
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				warning(ERR_READ, err, name+ARCHIVE_SEP+zf.Name)
+				continue
+			}
+			reason := scanEntry(zf.Name, rc)
+			rc.Close()
+			if reason == SKIP_TOO_LARGE {
+				return found, reason
+			}
+		}
+		return found, ""
+	}
+
+	var r io.Reader = f
+	if !strings.HasSuffix(p, ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			warning(ERR_READ, err, name)
+			return found, ERR_READ
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return found, ""
+		}
+		if err != nil {
+			warning(ERR_READ, err, name)
+			return found, ERR_READ
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if reason := scanEntry(hdr.Name, tr); reason == SKIP_TOO_LARGE {
+			return found, reason
+		}
+	}
+}
+
+// budgetReader fails with errArchiveTooLarge once the shared budget
+// of decompressed bytes is spent.
+type budgetReader struct {
+	r io.Reader
+	n int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		// Only an error if there is something left to read
+		var one [1]byte
+		if n, _ := b.r.Read(one[:]); n == 0 {
+			return 0, io.EOF
+		}
+		return 0, errArchiveTooLarge
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	return n, err
+}
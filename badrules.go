@@ -0,0 +1,18 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// writeBadRules saves the signatures in the JSON database format,
+// so the file can be fixed and passed back with -database.
+func writeBadRules(path string, sigs []scanner.Signature) error {
+	b, err := json.MarshalIndent(scanner.Database{Signatures: sigs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// checkDatabases loads the databases without stopping at the first
+// broken signature and reports every problem found: signatures that
+// fail to compile, duplicate ids, empty bodies, unknown severities
+// and patterns matching any content. If test is not empty, the
+// signatures matching it after normalization are listed as well.
+// Returns the number of problems.
+func checkDatabases(w io.Writer, paths []string, test string) int {
+	problems := 0
+	report := func(path string, format string, v ...interface{}) {
+		fmt.Fprintf(w, "%s: %s\n", path, fmt.Sprintf(format, v...))
+		problems++
+	}
+
+	var db scanner.Database
+	sources := make(map[int]string)
+	perSeverity := make(map[string]int)
+	now := time.Now()
+
+	for _, path := range paths {
+		b, err := scanner.FetchDatabase(path, fetchOpts)
+		if err != nil {
+			report(path, "%s", err)
+			continue
+		}
+		part, err := scanner.DecodeDatabase(b)
+		if err != nil {
+			report(path, "invalid database: %s", err)
+			continue
+		}
+		if len(part.Signatures) == 0 {
+			report(path, "no signatures")
+		}
+		if _, err := part.CompileNormalizers(); err != nil {
+			report(path, "%s", err)
+		}
+		db.Normalizers = append(db.Normalizers, part.Normalizers...)
+
+		for _, sig := range part.Signatures {
+			if src, ok := sources[sig.Id]; ok {
+				report(path, "duplicate signature id %d (already defined in %s)", sig.Id, src)
+				continue
+			}
+			sources[sig.Id] = path
+			sever, err := scanner.ParseSeverity(sig.Type)
+			if err != nil {
+				report(path, "signature %d: %s", sig.Id, err)
+			}
+			perSeverity[sever.String()]++
+			sig.Severity = sever
+
+			if _, err := sig.Expired(now); err != nil {
+				report(path, "signature %d: %s", sig.Id, err)
+			}
+			if len(strings.TrimSpace(sig.Signature)) == 0 {
+				report(path, "signature %d: empty signature", sig.Id)
+				continue
+			}
+			if err := sig.Compile(LONGEST); err != nil {
+				report(path, "%s", err)
+				continue
+			}
+			// An anchored pattern matching the empty string
+			// only flags the empty files
+			if sig.Mode == scanner.MODE_SEARCH {
+				switch {
+				case sig.Regexp.MatchString(""):
+					report(path, "signature %d: regexp %q matches the empty string, so it flags every file", sig.Id, sig.Signature)
+				case matchesAnything(sig.Regexp):
+					report(path, "signature %d: regexp %q matches any content, so it flags every file", sig.Id, sig.Signature)
+				}
+			}
+			sig.Source = path
+			db.Signatures = append(db.Signatures, sig)
+		}
+	}
+
+	severities := make([]string, 0, len(perSeverity))
+	total := 0
+	for sever, n := range perSeverity {
+		severities = append(severities, fmt.Sprintf("%s: %d", sever, n))
+		total += n
+	}
+	sort.Strings(severities)
+	fmt.Fprintf(w, "Signatures: %d (%s)\n", total, strings.Join(severities, ", "))
+	fmt.Fprintf(w, "Problems: %d\n", problems)
+
+	if len(test) > 0 && len(db.Signatures) > 0 {
+		nr, err := db.CompileNormalizers()
+		if err != nil {
+			nr, _ = scanner.CompileNormalizers()
+		}
+		s := scanner.NewScanner(&db, nr)
+		ms := s.ScanBytes([]byte(test))
+		if len(ms) == 0 {
+			fmt.Fprintln(w, "No signatures match the test string")
+		}
+		for _, m := range ms {
+			fmt.Fprintf(w, "Matched: %s (signature id = %d, severity = %s, database %s)\n", m.Title, m.Id, m.Type, m.Source)
+		}
+	}
+
+	return problems
+}
+
+// anythingProbes have nothing in common but being short, a regexp
+// matching all of them, such as "." or "[\s\S]", matches any file.
+var anythingProbes = []string{" ", "a", "Z", "0", "<?php", "\x00"}
+
+// matchesAnything reports whether the regexp matches all the probes.
+func matchesAnything(re *regexp.Regexp) bool {
+	for _, p := range anythingProbes {
+		if !re.MatchString(p) {
+			return false
+		}
+	}
+	return true
+}
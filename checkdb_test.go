@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCheckDatabasesBroadPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{`.`, "matches any content"},
+		{`[\s\S]`, "matches any content"},
+		{`(?s).+`, "matches any content"},
+		{`x*`, "matches the empty string"},
+		{`eval\s*\(`, ""},
+		{`\w`, ""},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "db.json")
+		db := `[{"id": 1, "title": "t", "sever": "c", "signature": ` + strconv.Quote(tt.pattern) + `}]`
+		if err := os.WriteFile(path, []byte(db), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		n := checkDatabases(&out, []string{path}, "")
+		switch {
+		case tt.want == "" && n != 0:
+			t.Errorf("%s: reported:\n%s", tt.pattern, out.String())
+		case tt.want != "" && (n != 1 || !strings.Contains(out.String(), tt.want)):
+			t.Errorf("%s: %d problems, want %q:\n%s", tt.pattern, n, tt.want, out.String())
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// Config is a snapshot of the effective settings of a run.
+type Config struct {
+	Database        []string     `json:"database"`
+	DatabaseGlob    string       `json:"database_glob"`
+	StrictDatabase  bool         `json:"strict_database"`
+	DatabaseTimeout string       `json:"database_timeout"`
+	DatabaseCache   string       `json:"database_cache"`
+	DatabaseRefresh bool         `json:"database_refresh"`
+	DatabaseSHA256  string       `json:"database_sha256,omitempty"`
+	RootDir         string       `json:"rootdir"`
+	Paths           []string     `json:"paths,omitempty"`
+	FollowSymlinks  bool         `json:"follow_symlinks"`
+	Watch           bool         `json:"watch"`
+	Workers         int          `json:"workers"`
+	Filter          []string     `json:"filter"`
+	Exclude         []string     `json:"exclude"`
+	SkipSoft        bool         `json:"skip_soft"`
+	Severity        SeverityList `json:"severity"`
+	MinSeverity     string       `json:"min_severity,omitempty"`
+	SkipSignatures  string       `json:"skip_signatures"`
+	OnlySignatures  string       `json:"only_signatures"`
+	IncludeExpired  bool         `json:"include_expired"`
+	IncludeDisabled bool         `json:"include_disabled"`
+	ScanStdin       bool         `json:"scan_stdin"`
+	DirSummary      bool         `json:"dir_summary"`
+	ExitMap         ExitMap      `json:"exit_map"`
+	Format          string       `json:"format"`
+	JSONStream      bool         `json:"json_stream"`
+	Verbose         bool         `json:"verbose"`
+	VeryVerbose     bool         `json:"very_verbose"`
+	LogFile         string       `json:"log_file,omitempty"`
+	LogLevel        string       `json:"log_level"`
+	Suppress        string       `json:"suppress"`
+	NewerThan       string       `json:"newer_than"`
+	LastRun         string       `json:"last_run"`
+	State           string       `json:"state"`
+	NoStateRead     bool         `json:"no_state_read"`
+	Output          string       `json:"output"`
+	Sort            string       `json:"sort"`
+	FailFast        bool         `json:"fail_fast"`
+	DecoderCmd      string       `json:"decoder_cmd"`
+	Decompress      bool         `json:"decompress"`
+	Embedded        bool         `json:"embedded"`
+	DecoderTimeout  string       `json:"decoder_timeout"`
+	Heuristics      int          `json:"heuristics"`
+	DetectEncoding  bool         `json:"detect_encoding"`
+	WritableBy      string       `json:"writable_by"`
+	Interactive     bool         `json:"interactive"`
+	Quarantine      string       `json:"quarantine"`
+	QuarantineSoft  bool         `json:"quarantine_soft"`
+	SFTP            string       `json:"sftp"`
+	OnlyOnMatch     bool         `json:"only_on_match"`
+	MaxFiles        int          `json:"max_files"`
+	ReportUnused    bool         `json:"report_unused"`
+	Longest         bool         `json:"longest"`
+	NoPrefilter     bool         `json:"no_prefilter"`
+	Mime            []string     `json:"mime,omitempty"`
+	AlsoScanMime    []string     `json:"also_scan_mime"`
+	SampleRate      float64      `json:"sample_rate"`
+	SampleSeed      int64        `json:"sample_seed"`
+	Evidence        string       `json:"evidence"`
+	EvidenceTar     bool         `json:"evidence_tar"`
+	Entropy         bool         `json:"entropy"`
+	BadRules        string       `json:"bad_rules"`
+	DecoderSummary  bool         `json:"decoder_summary"`
+	MaxTotalMatches int          `json:"max_total_matches"`
+	MaxSize         int64        `json:"max_size"`
+	Overlap         int          `json:"overlap"`
+	ScanArchives    bool         `json:"scan_archives"`
+	Allowlist       string       `json:"allowlist"`
+	Quiet           bool         `json:"quiet"`
+	ShowContext     bool         `json:"show_context"`
+	ContextBytes    int          `json:"context_bytes"`
+	Progress        bool         `json:"progress"`
+	FirstMatch      bool         `json:"first_match"`
+	PrintHash       bool         `json:"print_hash"`
+}
+
+// currentConfig collects the settings resolved from the command line.
+func currentConfig() Config {
+	filter := make([]string, 0, len(FFILTER))
+	for ext := range FFILTER {
+		filter = append(filter, ext)
+	}
+	sort.Strings(filter)
+
+	// The path arguments replace -rootdir
+	rootdir := ROOTDIR
+	if flag.NArg() > 0 {
+		rootdir = ""
+	}
+
+	return Config{
+		Database:        DBFILE.Paths,
+		DatabaseGlob:    DBGLOB,
+		StrictDatabase:  STRICTDB,
+		DatabaseTimeout: DBTIMEOUT.String(),
+		DatabaseCache:   DBCACHE,
+		DatabaseRefresh: DBREFRESH,
+		DatabaseSHA256:  DBSHA256,
+		RootDir:         rootdir,
+		Paths:           flag.Args(),
+		FollowSymlinks:  FOLLOWLINKS,
+		Watch:           WATCH,
+		Workers:         MAXPROCS,
+		Filter:          filter,
+		Exclude:         EXCLUDE,
+		SkipSoft:        SKIPSOFT,
+		Severity:        SEVERITY,
+		MinSeverity:     MINSEVERITY,
+		SkipSignatures:  SKIPSIG.String(),
+		OnlySignatures:  ONLYSIG.String(),
+		IncludeExpired:  INCEXPIRED,
+		IncludeDisabled: INCDISABLED,
+		ScanStdin:       SCANSTDIN,
+		DirSummary:      DIRSUMMARY,
+		ExitMap:         EXITMAP,
+		Format:          FORMAT,
+		JSONStream:      JSONSTREAM,
+		Verbose:         VERBOSE,
+		VeryVerbose:     VERYVERBOSE,
+		LogFile:         LOGFILE,
+		LogLevel:        logLevelName(logLevel),
+		Suppress:        SUPPRESS,
+		NewerThan:       NEWERTHAN,
+		LastRun:         LASTRUN,
+		State:           STATEFILE,
+		NoStateRead:     NOSTATEREAD,
+		Output:          OUTPUT,
+		Sort:            SORTBY,
+		FailFast:        FAILFAST,
+		DecoderCmd:      DECODERCMD,
+		Decompress:      DECOMPRESS,
+		Embedded:        EMBEDDED,
+		DecoderTimeout:  DECODERTTL.String(),
+		Heuristics:      HEURISTICS,
+		DetectEncoding:  DETECTENC,
+		WritableBy:      WRITABLEBY,
+		Interactive:     INTERACTIVE,
+		Quarantine:      QUARANTINE,
+		QuarantineSoft:  QUARSOFT,
+		SFTP:            SFTP,
+		OnlyOnMatch:     ONLYONMATCH,
+		MaxFiles:        MAXFILES,
+		ReportUnused:    REPORTUNUSED,
+		Longest:         LONGEST,
+		NoPrefilter:     NOPREFILTER,
+		Mime:            MIMETYPES,
+		AlsoScanMime:    ALSOMIME,
+		SampleRate:      SAMPLERATE,
+		SampleSeed:      SAMPLESEED,
+		Evidence:        EVIDENCE,
+		EvidenceTar:     EVIDENCETAR,
+		Entropy:         ENTROPY,
+		BadRules:        BADRULES,
+		DecoderSummary:  DECODERSUM,
+		MaxTotalMatches: MAXMATCHES,
+		MaxSize:         MAXSIZE,
+		Overlap:         OVERLAP,
+		ScanArchives:    SCANARCHIVES,
+		Allowlist:       ALLOWLIST,
+		Quiet:           QUIET,
+		ShowContext:     SHOWCONTEXT,
+		ContextBytes:    CONTEXTBYTES,
+		Progress:        PROGRESS,
+		FirstMatch:      FIRSTMATCH,
+		PrintHash:       PRINTHASH,
+	}
+}
+
+// Print writes the configuration as "key: value" lines
+// or as a single JSON object.
+func (c Config) Print(w io.Writer, asJSON bool) error {
+	if asJSON {
+		b, err := json.Marshal(struct {
+			Config Config `json:"config"`
+		}{c})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+
+	exitMap := "default"
+	if len(c.ExitMap) > 0 {
+		keys := make([]string, 0, len(c.ExitMap))
+		for k := range c.ExitMap {
+			keys = append(keys, fmt.Sprintf("%s=%d", k, c.ExitMap[k]))
+		}
+		sort.Strings(keys)
+		exitMap = strings.Join(keys, ",")
+	}
+	filter := "all text files"
+	if len(c.Filter) > 0 {
+		filter = strings.Join(c.Filter, ",")
+	}
+
+	decoder := c.DecoderCmd
+	if len(decoder) > 0 {
+		decoder = fmt.Sprintf("%s (timeout %s)", c.DecoderCmd, c.DecoderTimeout)
+	}
+
+	fields := []struct {
+		name  string
+		value interface{}
+	}{
+		{"database", strings.Join(c.Database, ",")},
+		{"database glob", c.DatabaseGlob},
+		{"strict database", c.StrictDatabase},
+		{"database timeout", c.DatabaseTimeout},
+		{"database cache", c.DatabaseCache},
+		{"database refresh", c.DatabaseRefresh},
+		{"database sha256", c.DatabaseSHA256},
+		{"rootdir", c.RootDir},
+		{"paths", strings.Join(c.Paths, ",")},
+		{"follow symlinks", c.FollowSymlinks},
+		{"watch", c.Watch},
+		{"workers", c.Workers},
+		{"filter", filter},
+		{"exclude", strings.Join(c.Exclude, ",")},
+		{"skip soft", c.SkipSoft},
+		{"severity", c.Severity.String()},
+		{"min severity", c.MinSeverity},
+		{"skip signatures", c.SkipSignatures},
+		{"only signatures", c.OnlySignatures},
+		{"include expired", c.IncludeExpired},
+		{"include disabled", c.IncludeDisabled},
+		{"scan stdin", c.ScanStdin},
+		{"dir summary", c.DirSummary},
+		{"exit map", exitMap},
+		{"format", c.Format},
+		{"json stream", c.JSONStream},
+		{"verbose", c.Verbose},
+		{"very verbose", c.VeryVerbose},
+		{"log file", c.LogFile},
+		{"log level", c.LogLevel},
+		{"suppress rules", c.Suppress},
+		{"newer than", c.NewerThan},
+		{"last run file", c.LastRun},
+		{"state file", c.State},
+		{"no state read", c.NoStateRead},
+		{"output", c.Output},
+		{"sort", c.Sort},
+		{"fail fast", c.FailFast},
+		{"decoder command", decoder},
+		{"decompress", c.Decompress},
+		{"embedded documents", c.Embedded},
+		{"heuristics", c.Heuristics},
+		{"detect encoding", c.DetectEncoding},
+		{"writable by", c.WritableBy},
+		{"interactive", c.Interactive},
+		{"quarantine", c.Quarantine},
+		{"quarantine soft", c.QuarantineSoft},
+		{"sftp", c.SFTP},
+		{"only on match", c.OnlyOnMatch},
+		{"max files", c.MaxFiles},
+		{"report unused", c.ReportUnused},
+		{"longest match", c.Longest},
+		{"no prefilter", c.NoPrefilter},
+		{"mime", strings.Join(c.Mime, ",")},
+		{"also scan mime", strings.Join(c.AlsoScanMime, ",")},
+		{"sample rate", c.SampleRate},
+		{"sample seed", c.SampleSeed},
+		{"evidence", c.Evidence},
+		{"evidence tar", c.EvidenceTar},
+		{"entropy", c.Entropy},
+		{"bad rules file", c.BadRules},
+		{"decoder summary", c.DecoderSummary},
+		{"max total matches", c.MaxTotalMatches},
+		{"max size", c.MaxSize},
+		{"overlap", c.Overlap},
+		{"scan archives", c.ScanArchives},
+		{"allowlist", c.Allowlist},
+		{"quiet", c.Quiet},
+		{"show context", c.ShowContext},
+		{"context bytes", c.ContextBytes},
+		{"progress", c.Progress},
+		{"first match", c.FirstMatch},
+		{"print hash", c.PrintHash},
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(tw, "Configuration:")
+	for _, f := range fields {
+		fmt.Fprintf(tw, "  %s:\t%v\n", f.name, f.value)
+	}
+	return tw.Flush()
+}
+
+// RunInfo identifies the ruleset and settings of a run, so that
+// two runs can be proven to use the same configuration.
+type RunInfo struct {
+	ConfigHash          string `json:"config_hash"`
+	DatabaseFingerprint string `json:"database_fingerprint"`
+	Signatures          int    `json:"signatures"`
+
+	// The account the scan ran under. Unprivileged scans
+	// may miss files that are not readable by this account.
+	User       string `json:"user"`
+	Uid        string `json:"uid"`
+	Privileged bool   `json:"privileged"`
+}
+
+func newRunInfo(db *scanner.Database, configHash string) RunInfo {
+	ri := RunInfo{
+		ConfigHash:          configHash,
+		DatabaseFingerprint: db.Fingerprint(),
+		Signatures:          len(db.Signatures),
+		Uid:                 strconv.Itoa(os.Geteuid()),
+		Privileged:          os.Geteuid() == 0,
+	}
+	if u, err := user.Current(); err == nil {
+		ri.User = u.Username
+		ri.Uid = u.Uid
+	}
+
+	return ri
+}
+
+// configHash returns the digest of the settings that decide what a scan
+// detects: the state fingerprint, which covers the signatures, the
+// normalizers, the detection options and the suppress rules, then the
+// signature and file filters and the allowlist. The paths, the output
+// and the performance settings are left out, so the hosts scanning
+// by the same rules report the same hash.
+func configHash(fingerprint string, lo scanner.LoadOptions, opts *scanner.Options, allow Allowlist) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "state\x00%s\x00", fingerprint)
+
+	fmt.Fprintf(h, "signatures\x00%t\x00%t\x00%t\x00%v\x00%s\x00%v\x00%v\x00",
+		lo.IncludeDisabled, lo.IncludeExpired, lo.Longest, lo.Severities, lo.MinSeverity, lo.SkipIds, lo.OnlyIds)
+
+	exts := make([]string, 0, len(opts.Extensions))
+	for ext := range opts.Extensions {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	fmt.Fprintf(h, "files\x00%v\x00%s\x00%s\x00%t\x00", exts, opts.ContentTypes, opts.AlsoContentTypes, opts.FirstMatch)
+
+	digests := make([]string, 0, len(allow))
+	for d := range allow {
+		digests = append(digests, d)
+	}
+	sort.Strings(digests)
+	for _, d := range digests {
+		fmt.Fprintf(h, "allow\x00%s\x00", d)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Print writes the run information as text or as a single JSON object.
+func (ri RunInfo) Print(w io.Writer, asJSON bool) error {
+	if asJSON {
+		b, err := json.Marshal(struct {
+			Run RunInfo `json:"run"`
+		}{ri})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+
+	priv := "unprivileged"
+	if ri.Privileged {
+		priv = "privileged"
+	}
+
+	fmt.Fprintf(w, "Run: user %s (uid %s, %s)\n", ri.User, ri.Uid, priv)
+	_, err := fmt.Fprintf(w, "Run: config hash %s, database fingerprint %s (%d signatures)\n",
+		ri.ConfigHash, ri.DatabaseFingerprint, ri.Signatures)
+	return err
+}
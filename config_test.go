@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func TestConfigHash(t *testing.T) {
+	fp := "state"
+	base := func() (scanner.LoadOptions, scanner.Options, Allowlist) {
+		return scanner.LoadOptions{FetchOptions: scanner.FetchOptions{CacheDir: "/var/cache/a"}},
+			scanner.Options{MaxSize: 1 << 20, Extensions: map[string]struct{}{".php": {}, ".inc": {}}},
+			Allowlist{"aa": {}, "bb": {}}
+	}
+	lo, opts, allow := base()
+	want := configHash(fp, lo, &opts, allow)
+
+	// The settings that do not change what is detected
+	lo.CacheDir = "/var/cache/b"
+	lo.Strict = true
+	opts.Entropy = true
+	opts.ContextRadius = 100
+	opts.DecoderTimeout = 1
+	if got := configHash(fp, lo, &opts, allow); got != want {
+		t.Errorf("paths and output settings changed the hash")
+	}
+
+	changes := map[string]func(*scanner.LoadOptions, *scanner.Options, Allowlist){
+		"min severity": func(lo *scanner.LoadOptions, _ *scanner.Options, _ Allowlist) {
+			lo.MinSeverity = scanner.SEVERITY_CRITICAL
+		},
+		"only ids": func(lo *scanner.LoadOptions, _ *scanner.Options, _ Allowlist) {
+			lo.OnlyIds = scanner.IdRanges{{From: 1, To: 5}}
+		},
+		"extensions": func(_ *scanner.LoadOptions, o *scanner.Options, _ Allowlist) { o.Extensions[".txt"] = struct{}{} },
+		"mime": func(_ *scanner.LoadOptions, o *scanner.Options, _ Allowlist) {
+			o.ContentTypes = scanner.MimeList{"text/"}
+		},
+		"allowlist": func(_ *scanner.LoadOptions, _ *scanner.Options, a Allowlist) { a["cc"] = struct{}{} },
+	}
+	for name, change := range changes {
+		lo, opts, allow := base()
+		change(&lo, &opts, allow)
+		if got := configHash(fp, lo, &opts, allow); got == want {
+			t.Errorf("%s: the hash did not change", name)
+		}
+	}
+	if got := configHash("other state", lo, &opts, allow); got == want {
+		t.Error("state fingerprint: the hash did not change")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Counter is a thread-safe set of counters grouped by a string key.
+type Counter struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+func NewCounter() *Counter {
+	return &Counter{m: make(map[string]int)}
+}
+
+func (c *Counter) Add(key string) {
+	c.mu.Lock()
+	c.m[key]++
+	c.mu.Unlock()
+}
+
+// Print writes the counters sorted by value in descending order.
+func (c *Counter) Print(w io.Writer, title string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.m))
+	for k := range c.m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if c.m[keys[i]] == c.m[keys[j]] {
+			return keys[i] < keys[j]
+		}
+		return c.m[keys[i]] > c.m[keys[j]]
+	})
+
+	fmt.Fprintf(w, "%s:\n", title)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %8d  %s\n", c.m[k], k)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// EVIDENCE_MAXBYTES limits the copy of a single file in the bundle,
+// the rest of a larger file is left out.
+const EVIDENCE_MAXBYTES = 64 * 1024 * 1024 // 64M
+
+// evidenceRecord describes a collected file in the bundle manifest.
+type evidenceRecord struct {
+	Path    string     `json:"path"`
+	Stored  string     `json:"stored,omitempty"`
+	Sha256  string     `json:"sha256,omitempty"`
+	Size    int64      `json:"size,omitempty"`
+	Mode    string     `json:"mode,omitempty"`
+	ModTime *time.Time `json:"mtime,omitempty"`
+	Id      int        `json:"signature_id"`
+	Title   string     `json:"title"`
+	Type    string     `json:"type"`
+
+	// Only the first EVIDENCE_MAXBYTES of the file are stored
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Why the content is not stored
+	Note string `json:"note,omitempty"`
+}
+
+// Evidence collects copies of the matched files together with a manifest
+// for offline analysis. Unlike quarantine, the original files stay
+// in place. The bundle is either a directory or a gzipped tarball.
+type Evidence struct {
+	Path string
+
+	mu       sync.Mutex
+	stored   map[string]evidenceRecord
+	manifest bytes.Buffer
+
+	// directory bundle
+	dir string
+
+	// tarball bundle
+	f  *os.File
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+// NewEvidence creates a timestamped bundle inside the parent directory.
+func NewEvidence(parent string, asTar bool) (*Evidence, error) {
+	name := "rigel-evidence-" + time.Now().Format("20060102T150405")
+
+	if err := os.MkdirAll(parent, 0700); err != nil {
+		return nil, err
+	}
+
+	e := Evidence{stored: make(map[string]evidenceRecord)}
+
+	if asTar {
+		e.Path = filepath.Join(parent, name+".tar.gz")
+		f, err := os.OpenFile(e.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return nil, err
+		}
+		e.f = f
+		e.gz = gzip.NewWriter(f)
+		e.tw = tar.NewWriter(e.gz)
+	} else {
+		e.Path = filepath.Join(parent, name)
+		if err := os.Mkdir(e.Path, 0700); err != nil {
+			return nil, err
+		}
+		e.dir = e.Path
+	}
+
+	return &e, nil
+}
+
+// Add copies the matched file into the bundle once and records
+// the match in the manifest. The matches of an archive entry store
+// the archive. The content of the standard input and of the remote
+// files is not stored, their matches are only recorded.
+func (e *Evidence) Add(m scanner.Match) error {
+	rec := evidenceRecord{
+		Path:  m.Path,
+		Id:    m.Id,
+		Title: m.Title,
+		Type:  m.Type.String(),
+	}
+
+	source := m.Path
+	if i := strings.Index(source, ARCHIVE_SEP); i >= 0 {
+		source = source[:i]
+	}
+	if !localFile(source) {
+		rec.Note = "not a local file, the content is not stored"
+		return e.record(rec)
+	}
+
+	e.mu.Lock()
+	file, ok := e.stored[source]
+	e.mu.Unlock()
+
+	// The file is read without holding the lock,
+	// so the other workers are not stalled by a large copy
+	var b []byte
+	var st os.FileInfo
+	if !ok {
+		var err error
+		if b, st, file, err = readEvidence(source); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Another worker may have stored the file in the meantime
+	if stored, ok := e.stored[source]; ok {
+		file = stored
+	} else if b != nil {
+		if err := e.store(file.Stored, b, st); err != nil {
+			return err
+		}
+		e.stored[source] = file
+	}
+
+	rec.Stored = file.Stored
+	rec.Sha256 = file.Sha256
+	rec.Size = file.Size
+	rec.Mode = file.Mode
+	rec.ModTime = file.ModTime
+	rec.Truncated = file.Truncated
+
+	return e.writeRecord(rec)
+}
+
+// readEvidence reads at most EVIDENCE_MAXBYTES of the file, the digest
+// and the size in the returned record are of the whole file.
+func readEvidence(path string) ([]byte, os.FileInfo, evidenceRecord, error) {
+	var rec evidenceRecord
+
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, nil, rec, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, nil, rec, err
+	}
+
+	h := sha256.New()
+	b, err := ioutil.ReadAll(io.LimitReader(io.TeeReader(f, h), EVIDENCE_MAXBYTES))
+	if err != nil {
+		return nil, nil, rec, err
+	}
+	rest, err := io.Copy(h, f)
+	if err != nil {
+		return nil, nil, rec, err
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, rec, err
+	}
+	rec.Stored = filepath.ToSlash(filepath.Join("files", strings.TrimPrefix(abs, filepath.VolumeName(abs))))
+	rec.Sha256 = hex.EncodeToString(h.Sum(nil))
+	rec.Size = int64(len(b)) + rest
+	rec.Mode = st.Mode().String()
+	mtime := st.ModTime()
+	rec.ModTime = &mtime
+	rec.Truncated = rest > 0
+
+	return b, st, rec, nil
+}
+
+// record adds the record to the manifest.
+func (e *Evidence) record(rec evidenceRecord) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.writeRecord(rec)
+}
+
+// writeRecord adds the record to the manifest, e.mu must be held.
+func (e *Evidence) writeRecord(rec evidenceRecord) error {
+	j, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	e.manifest.Write(j)
+	e.manifest.WriteByte('\n')
+
+	return nil
+}
+
+func (e *Evidence) store(name string, b []byte, st os.FileInfo) error {
+	if e.tw != nil {
+		hdr := tar.Header{
+			Name:    name,
+			Mode:    int64(st.Mode().Perm()),
+			Size:    int64(len(b)),
+			ModTime: st.ModTime(),
+		}
+		if err := e.tw.WriteHeader(&hdr); err != nil {
+			return err
+		}
+		_, err := e.tw.Write(b)
+		return err
+	}
+
+	dst := filepath.Join(e.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(dst, b, 0600); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, st.ModTime(), st.ModTime())
+}
+
+// Close writes the manifest and finalizes the bundle.
+func (e *Evidence) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.tw == nil {
+		return ioutil.WriteFile(filepath.Join(e.dir, "manifest.jsonl"), e.manifest.Bytes(), 0600)
+	}
+
+	hdr := tar.Header{
+		Name:    "manifest.jsonl",
+		Mode:    0600,
+		Size:    int64(e.manifest.Len()),
+		ModTime: time.Now(),
+	}
+	err := e.tw.WriteHeader(&hdr)
+	if err == nil {
+		_, err = io.Copy(e.tw, &e.manifest)
+	}
+	for _, c := range []io.Closer{e.tw, e.gz, e.f} {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot finalize %s: %s", e.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func readManifest(t *testing.T, path string) []evidenceRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var recs []evidenceRecord
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var rec evidenceRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestEvidenceAdd(t *testing.T) {
+	saved := fsys
+	defer func() { fsys = saved }()
+	fsys = scanner.LocalFS{}
+
+	dir := t.TempDir()
+	shell := filepath.Join(dir, "shell.php")
+	archive := filepath.Join(dir, "backup.zip")
+	content := []byte("<?php eval($_POST['c']);")
+	for _, p := range []string{shell, archive} {
+		if err := os.WriteFile(p, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := NewEvidence(filepath.Join(dir, "evidence"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Several workers report matches of the same files at once
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for _, path := range []string{shell, archive + ARCHIVE_SEP + "x.php", STDIN_NAME} {
+				if err := e.Add(scanner.Match{Path: path, Id: id, Title: "sig"}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	recs := readManifest(t, filepath.Join(e.Path, "manifest.jsonl"))
+	if len(recs) != 24 {
+		t.Fatalf("%d records, want 24", len(recs))
+	}
+	for _, rec := range recs {
+		switch {
+		case rec.Path == STDIN_NAME:
+			if len(rec.Stored) > 0 || len(rec.Note) == 0 {
+				t.Errorf("stdin: stored %q, note %q", rec.Stored, rec.Note)
+			}
+		case rec.Path == shell || strings.HasPrefix(rec.Path, archive+ARCHIVE_SEP):
+			if rec.Sha256 != digest || rec.Size != int64(len(content)) || rec.Truncated {
+				t.Errorf("%s: %+v", rec.Path, rec)
+			}
+			b, err := os.ReadFile(filepath.Join(e.Path, filepath.FromSlash(rec.Stored)))
+			if err != nil || string(b) != string(content) {
+				t.Errorf("%s: stored copy %q, %v", rec.Path, b, err)
+			}
+		default:
+			t.Errorf("unexpected record %+v", rec)
+		}
+	}
+}
+
+func TestEvidenceNotLocal(t *testing.T) {
+	saved := fsys
+	defer func() { fsys = saved }()
+	fsys = &sftpFS{prefix: "user@host:"}
+
+	e, err := NewEvidence(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Add(scanner.Match{Path: "user@host:/srv/www/shell.php", Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	e.Close()
+
+	recs := readManifest(t, filepath.Join(e.Path, "manifest.jsonl"))
+	if len(recs) != 1 || len(recs[0].Stored) > 0 || len(recs[0].Note) == 0 {
+		t.Errorf("got %+v, want the match recorded without content", recs)
+	}
+}
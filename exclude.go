@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// ExcludeList is a list of glob patterns of the paths pruned during
+// the walk. A pattern matches either the base name of a path
+// ("node_modules") or the path relative to its root ("*/cache/*").
+// The roots themselves are never excluded.
+type ExcludeList []string
+
+func (el ExcludeList) String() string {
+	return strings.Join(el, ",")
+}
+
+func (el *ExcludeList) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		// Reject malformed patterns early, filepath.Match
+		// only reports them when it gets to the bad part
+		if _, err := filepath.Match(s, ""); err != nil {
+			return err
+		}
+		*el = append(*el, filepath.ToSlash(s))
+	}
+	return nil
+}
+
+// Match reports whether the path found under the root is excluded.
+// A directory is also matched with a trailing slash, so "*/cache/*"
+// prunes the cache directories instead of only skipping their files.
+func (el ExcludeList) Match(root, path string, dir bool) bool {
+	if len(el) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	if rel == "." {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	base := filepath.Base(path)
+
+	for _, p := range el {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, rel+"/"); ok && dir {
+			return true
+		}
+	}
+	return false
+}
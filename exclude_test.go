@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func TestExcludeListMatch(t *testing.T) {
+	root := filepath.FromSlash("/var/www")
+	tests := []struct {
+		patterns string
+		path     string
+		dir      bool
+		want     bool
+	}{
+		{"node_modules", "/var/www/node_modules", true, true},
+		{"node_modules", "/var/www/app/node_modules", true, true},
+		{"node_modules", "/var/www/app/node_modules.php", false, false},
+		{"*.min.js", "/var/www/js/app.min.js", false, true},
+		{"*.min.js", "/var/www/js/app.js", false, false},
+		{"*/cache/*", "/var/www/site/cache", true, true},
+		{"*/cache/*", "/var/www/site/cache", false, false},
+		{"*/cache/*", "/var/www/site/cache/page.php", false, true},
+		{"*/cache/*", "/var/www/cache", true, false},
+		{"site/*", "/var/www/site/index.php", false, true},
+		{"site/*", "/var/www/other/site/index.php", false, false},
+		// The root is never excluded, whatever its name
+		{"www", "/var/www", true, false},
+		{"*", "/var/www", true, false},
+		{"www", "/var/www/www", true, true},
+	}
+	for _, tt := range tests {
+		var el ExcludeList
+		if err := el.Set(tt.patterns); err != nil {
+			t.Fatal(err)
+		}
+		if got := el.Match(root, filepath.FromSlash(tt.path), tt.dir); got != tt.want {
+			t.Errorf("%q: Match(%s, dir %v) = %v, want %v", tt.patterns, tt.path, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestExcludeListSet(t *testing.T) {
+	var el ExcludeList
+	if err := el.Set("a, b,,c/*"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (ExcludeList{"a", "b", "c/*"}); !reflect.DeepEqual(el, want) {
+		t.Errorf("got %v, want %v", el, want)
+	}
+	if err := el.Set("[a-"); err == nil {
+		t.Error("malformed pattern accepted")
+	}
+}
+
+// walkTree creates the files under a new root and returns
+// the files found by the walk relative to the root.
+func walkTree(t *testing.T, patterns string, rootName string, files ...string) []string {
+	saved := EXCLUDE
+	t.Cleanup(func() { EXCLUDE = saved })
+	EXCLUDE = nil
+	if err := EXCLUDE.Set(patterns); err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(t.TempDir(), rootName)
+	for _, f := range files {
+		path := filepath.Join(root, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("<?php\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var found []string
+	for path := range walk(context.Background(), &scanner.Scanner{}, []string{root}) {
+		rel, _ := filepath.Rel(root, path)
+		found = append(found, filepath.ToSlash(rel))
+	}
+	sort.Strings(found)
+	return found
+}
+
+func TestWalkExclude(t *testing.T) {
+	files := []string{
+		"cache/a.php",
+		"index.php",
+		"site/cache/b.php",
+		"site/cache/deep/c.php",
+		"site/lib/cache/d.php",
+		"site/lib/e.php",
+		"vendor/backup/f.php",
+		"vendor/g.php",
+	}
+	tests := []struct {
+		patterns string
+		want     []string
+	}{
+		{"", files},
+		// Nested excluded directories are pruned at any depth
+		{"cache", []string{"index.php", "site/lib/e.php", "vendor/backup/f.php", "vendor/g.php"}},
+		{"*/cache/*", []string{"cache/a.php", "index.php", "site/lib/cache/d.php", "site/lib/e.php", "vendor/backup/f.php", "vendor/g.php"}},
+		{"vendor/backup,site", []string{"cache/a.php", "index.php", "vendor/g.php"}},
+	}
+	for _, tt := range tests {
+		if got := walkTree(t, tt.patterns, "www", files...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestWalkExcludeRoot(t *testing.T) {
+	// A pattern matching the root itself does not prune the whole scan
+	got := walkTree(t, "www", "www", "index.php", "www/nested.php")
+	if want := []string{"index.php"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	got = walkTree(t, "cache", "cache", "index.php")
+	if want := []string{"index.php"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFileListExclude(t *testing.T) {
+	saved := EXCLUDE
+	defer func() { EXCLUDE = saved }()
+	EXCLUDE = nil
+	EXCLUDE.Set("*/cache/*,*.min.js")
+
+	dir := t.TempDir()
+	files := []string{"site/cache/a.php", "site/b.php", "site/js/app.min.js", "cache/c.php"}
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte("<?php\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// The listed paths are matched relative to the current directory,
+	// whether they are listed as relative or absolute
+	list := strings.Join(files, "\n") + "\n" + filepath.Join(dir, "site", "cache", "a.php") + "\n"
+
+	var found []string
+	for path := range readFileList(context.Background(), &scanner.Scanner{}, strings.NewReader(list)) {
+		found = append(found, filepath.ToSlash(path))
+	}
+	sort.Strings(found)
+	if want := []string{"cache/c.php", "site/b.php"}; !reflect.DeepEqual(found, want) {
+		t.Errorf("got %v, want %v", found, want)
+	}
+}
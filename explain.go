@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// explainFile runs the detection on a single local file and writes
+// every decision it makes: the file checks done by the walker
+// and the steps of the scanner pipeline. Returns whether the scan
+// would report the file.
+func explainFile(w io.Writer, scn *scanner.Scanner, path string) (bool, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if st.IsDir() {
+		return false, fmt.Errorf("%s is a directory", path)
+	}
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Fprintf(w, "File: %s (%d bytes)\n", path, len(c))
+
+	// The walker and checkFile filters
+	skip := ""
+	if len(scn.Extensions) > 0 {
+		if !scn.AcceptName(path) {
+			skip = "extension is not in -filter"
+		}
+		fmt.Fprintf(w, "Filter: extension %q\n", filepath.Ext(path))
+	}
+	if scn.CheckContentType() {
+		mimeType := scanner.DetectContentType(c)
+		fmt.Fprintf(w, "MIME: %s\n", mimeType)
+		if !scn.AcceptContentType(mimeType) && len(skip) == 0 {
+			skip = "content type is not scanned"
+		}
+	}
+	if len(c) == 0 {
+		skip = "file is empty"
+	}
+	// The scanner option, not -max-size, so it agrees with the scan
+	if scn.MaxSize <= 0 || int64(len(c)) > scn.MaxSize {
+		fmt.Fprintf(w, "Size: %d bytes, streamed in windows during the scan (the whole content is traced below)\n", len(c))
+	}
+	if len(skip) > 0 {
+		fmt.Fprintf(w, "Skipped: %s (the steps below are shown anyway)\n", skip)
+	}
+
+	flagged := scn.Explain(w, path, c) && len(skip) == 0
+	if flagged {
+		fmt.Fprintln(w, "Result: flagged")
+	} else {
+		fmt.Fprintln(w, "Result: not flagged")
+	}
+
+	return flagged, nil
+}
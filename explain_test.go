@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func TestExplainFileMaxSize(t *testing.T) {
+	db, err := scanner.LoadDatabase(strings.NewReader(`<?xml version="1.0"?>
+<database>
+<signature id="1" title="eval-post" sever="c">eval\s*\(\s*\$_POST</signature>
+</database>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nr, _ := db.CompileNormalizers()
+	scn := scanner.NewScanner(db, nr)
+
+	path := filepath.Join(t.TempDir(), "shell.php")
+	if err := os.WriteFile(path, []byte("<?php eval($_POST['c']);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// -max-size is not consulted, the options of the scanner are
+	saved := MAXSIZE
+	defer func() { MAXSIZE = saved }()
+	MAXSIZE = 1
+
+	for _, tt := range []struct {
+		maxSize  int64
+		streamed bool
+	}{
+		{1 << 20, false},
+		{8, true},
+		{0, true},
+	} {
+		scn.MaxSize = tt.maxSize
+		var out bytes.Buffer
+		flagged, err := explainFile(&out, scn, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !flagged {
+			t.Errorf("max size %d: not flagged:\n%s", tt.maxSize, out.String())
+		}
+		if got := strings.Contains(out.String(), "streamed in windows"); got != tt.streamed {
+			t.Errorf("max size %d: streamed %v, want %v:\n%s", tt.maxSize, got, tt.streamed, out.String())
+		}
+	}
+}
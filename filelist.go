@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// readFileList sends the paths listed in r, one per line, to the returned
+// channel instead of walking the roots. Blank lines and lines starting
+// with # are skipped. The paths go through the same filters as the files
+// found by the walk. The -exclude patterns are matched against the paths
+// relative to the current directory, the default rootdir, or to the
+// filesystem root for the paths outside of it.
+func readFileList(ctx context.Context, scn *scanner.Scanner, r io.Reader) <-chan string {
+	cPaths := make(chan string, 10)
+	visited := make(map[string]struct{})
+
+	roots := []string{string(filepath.Separator)}
+	if wd, err := os.Getwd(); err == nil {
+		roots = append(roots, wd)
+	}
+
+	go func() {
+		defer close(cPaths)
+
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			path := strings.TrimSpace(s.Text())
+			if len(path) == 0 || path[0] == '#' {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				reason := errReason(err, ERR_READ)
+				warning(reason, err, path)
+				stats.Record(reason)
+				continue
+			}
+			if info.IsDir() {
+				warning(SKIP_FILTERED, "directories are not scanned from the file list", path)
+				continue
+			}
+			if abs, err := filepath.Abs(path); err == nil && EXCLUDE.Match(rootOf(roots, abs), abs, false) {
+				atomic.AddInt64(&excludedPaths, 1)
+				skipped(SKIP_FILTERED, "matches -exclude", path)
+				continue
+			}
+			ok, err := acceptFile(scn, path, info, visited)
+			if err != nil {
+				return
+			}
+			if !ok {
+				continue
+			}
+			select {
+			case cPaths <- path:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := s.Err(); err != nil {
+			warning(ERR_READ, err, FILESFROM)
+		}
+	}()
+
+	return cPaths
+}
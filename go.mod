@@ -0,0 +1,12 @@
+module github.com/0xef53/rigel
+
+go 1.21
+
+require (
+	github.com/pkg/sftp v1.13.7
+	golang.org/x/crypto v0.31.0
+	golang.org/x/sys v0.28.0
+	golang.org/x/text v0.21.0
+)
+
+require github.com/kr/fs v0.1.0 // indirect
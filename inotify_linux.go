@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Events of the watched directories. Written files are reported
+// on every write and when they are closed, so the scans of a file
+// that is still being written can be debounced.
+const inotifyMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO
+
+// fsWatcher reports the files created or written
+// in the watched directories through inotify.
+type fsWatcher struct {
+	f *os.File
+
+	mu   sync.Mutex
+	dirs map[int]string
+}
+
+func newWatcher() (*fsWatcher, error) {
+	// A non-blocking descriptor is handled by the runtime poller,
+	// so Close interrupts a pending Read
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %s", err)
+	}
+	return &fsWatcher{
+		f:    os.NewFile(uintptr(fd), "inotify"),
+		dirs: make(map[int]string),
+	}, nil
+}
+
+// Add starts watching the directory.
+func (w *fsWatcher) Add(dir string) error {
+	wd, err := unix.InotifyAddWatch(int(w.f.Fd()), dir, inotifyMask)
+	if err == unix.ENOSPC {
+		return errWatchLimit
+	}
+	if err != nil {
+		return &os.PathError{Op: "watch", Path: dir, Err: err}
+	}
+	w.mu.Lock()
+	w.dirs[wd] = dir
+	w.mu.Unlock()
+	return nil
+}
+
+// Read waits for the events and calls fn with the path of each
+// created or written file or directory. It returns when the watcher
+// is closed.
+func (w *fsWatcher) Read(fn func(path string, dir bool)) error {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+			off += unix.SizeofInotifyEvent + int(ev.Len)
+
+			if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+				log.Println("[warning] inotify event queue overflowed, some changes were not scanned")
+				continue
+			}
+
+			w.mu.Lock()
+			dir, ok := w.dirs[int(ev.Wd)]
+			if ev.Mask&unix.IN_IGNORED != 0 {
+				delete(w.dirs, int(ev.Wd))
+			}
+			w.mu.Unlock()
+
+			if !ok || len(name) == 0 || ev.Mask&inotifyMask == 0 {
+				continue
+			}
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
+			}
+			fn(filepath.Join(dir, string(name)), ev.Mask&unix.IN_ISDIR != 0)
+		}
+	}
+}
+
+func (w *fsWatcher) Close() error {
+	return w.f.Close()
+}
+
+var errWatchLimit = errors.New("inotify watch limit reached, raise it with 'sysctl fs.inotify.max_user_watches=N'")
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// Number of bytes shown on each side of a match.
+const SNIPPET_RADIUS = 120
+
+// ReviewQueue collects matches to be reviewed by the operator
+// when the scan is finished.
+type ReviewQueue struct {
+	mu    sync.Mutex
+	items []scanner.Match
+}
+
+var reviewQueue ReviewQueue
+
+// Add queues the match, its Context is shown to the operator.
+func (q *ReviewQueue) Add(m scanner.Match) {
+	q.mu.Lock()
+	q.items = append(q.items, m)
+	q.mu.Unlock()
+}
+
+// Run steps through the collected matches and performs
+// the actions chosen by the operator.
+func (q *ReviewQueue) Run(in io.Reader, out io.Writer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	r := bufio.NewReader(in)
+	ask := func(prompt string) string {
+		fmt.Fprint(out, prompt)
+		line, _ := r.ReadString('\n')
+		return strings.ToLower(strings.TrimSpace(line))
+	}
+
+	handled := make(map[string]bool)
+
+	for i, it := range q.items {
+		if handled[it.Path] {
+			continue
+		}
+
+		fmt.Fprintf(out, "\n[%d/%d] %s\n", i+1, len(q.items), it.Path)
+		fmt.Fprintf(out, "Signature: %s (id = %d, severity = %s)\n", it.Title, it.Id, it.Type)
+		fmt.Fprintf(out, "----\n%s\n----\n", it.Context)
+
+		// The files that are not local cannot be moved or removed here
+		local := localFile(it.Path)
+		// Suppressing adds a -suppress rule for the signature and the path,
+		// the -allowlist of known-good digests is not touched
+		actions := "[q]uarantine, [d]elete, s[u]ppress, [s]kip, e[x]it? "
+		if !local {
+			fmt.Fprintln(out, "Not a local file, it can only be suppressed or skipped")
+			actions = "s[u]ppress, [s]kip, e[x]it? "
+		}
+
+	prompt:
+		for {
+			switch ask(actions) {
+			case "q":
+				if !local {
+					continue
+				}
+				dst, err := quarantine.Move(it.Path, []scanner.Match{it})
+				if err != nil {
+					fmt.Fprintln(out, "Error:", err)
+					continue
+				}
+				fmt.Fprintln(out, "Moved to", dst)
+				handled[it.Path] = true
+			case "d":
+				if !local {
+					continue
+				}
+				if ask("Delete the file permanently? [y/N] ") != "y" {
+					continue
+				}
+				if err := os.Remove(it.Path); err != nil {
+					fmt.Fprintln(out, "Error:", err)
+					continue
+				}
+				fmt.Fprintln(out, "Deleted")
+				handled[it.Path] = true
+			case "u":
+				if len(SUPPRESS) == 0 {
+					fmt.Fprintln(out, "Error: no -suppress file given to store the rule in")
+					continue
+				}
+				if err := appendSuppressRule(SUPPRESS, it.Id, it.Path); err != nil {
+					fmt.Fprintln(out, "Error:", err)
+					continue
+				}
+				fmt.Fprintln(out, "Added to", SUPPRESS)
+			case "s", "":
+			case "x":
+				return
+			default:
+				continue
+			}
+			break prompt
+		}
+	}
+}
+
+// appendSuppressRule adds a rule for the exact path to the suppress file.
+func appendSuppressRule(file string, id int, path string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%d %s\n", id, escapeGlob(path)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// escapeGlob quotes the glob metacharacters in the path.
+func escapeGlob(path string) string {
+	r := strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`)
+	return r.Replace(path)
+}
+
+// isTerminal reports whether the file is a character device.
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
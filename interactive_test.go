@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func TestReviewQueueNotLocal(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "a.zip")
+	if err := os.WriteFile(archive, []byte("PK"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := quarantine
+	quarantine = &Quarantine{Dir: filepath.Join(dir, "quarantine")}
+	defer func() { quarantine = saved }()
+
+	for _, path := range []string{archive + ARCHIVE_SEP + "shell.php", STDIN_NAME} {
+		var q ReviewQueue
+		q.Add(scanner.Match{Path: path, Id: 1, Title: "eval-post"})
+
+		var out bytes.Buffer
+		q.Run(strings.NewReader("q\nd\ny\ns\n"), &out)
+
+		if !strings.Contains(out.String(), "Not a local file") {
+			t.Errorf("%s: not reported as not local:\n%s", path, out.String())
+		}
+		if strings.Contains(out.String(), "[q]uarantine") || strings.Contains(out.String(), "Moved") || strings.Contains(out.String(), "Deleted") {
+			t.Errorf("%s: file actions offered or taken:\n%s", path, out.String())
+		}
+	}
+	if _, err := os.Stat(archive); err != nil {
+		t.Errorf("the archive is gone: %s", err)
+	}
+}
+
+func TestReviewQueueQuarantine(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "sub", "shell.php")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("<?php eval($_POST['c']);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The interactive mode uses the quarantine of the scan
+	// and places the file by its path under the root
+	saved := quarantine
+	quarantine = &Quarantine{Dir: filepath.Join(t.TempDir(), "quarantine"), Roots: []string{root}}
+	defer func() { quarantine = saved }()
+
+	var q ReviewQueue
+	q.Add(scanner.Match{Path: path, Id: 1, Title: "eval-post"})
+	var out bytes.Buffer
+	q.Run(strings.NewReader("q\n"), &out)
+
+	dst := filepath.Join(quarantine.Dir, "sub", "shell.php")
+	if !strings.Contains(out.String(), "Moved to "+dst) {
+		t.Errorf("not moved to %s:\n%s", dst, out.String())
+	}
+	if _, err := os.Stat(filepath.Join(quarantine.Dir, QUARANTINE_MANIFEST)); err != nil {
+		t.Errorf("no manifest: %s", err)
+	}
+}
+
+func TestReviewQueueSuppress(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "suppress.txt")
+	saved := SUPPRESS
+	SUPPRESS = file
+	defer func() { SUPPRESS = saved }()
+
+	var q ReviewQueue
+	q.Add(scanner.Match{Path: "/srv/www/a*.php", Id: 7, Title: "eval-post"})
+	var out bytes.Buffer
+	// "w" is no action, the prompt is repeated
+	q.Run(strings.NewReader("w\nu\n"), &out)
+
+	if !strings.Contains(out.String(), "s[u]ppress") || strings.Contains(out.String(), "whitelist") {
+		t.Errorf("actions offered:\n%s", out.String())
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "7 /srv/www/a\\*.php\n" {
+		t.Errorf("suppress file %q", b)
+	}
+}
+
+func TestLocalFile(t *testing.T) {
+	saved := fsys
+	defer func() { fsys = saved }()
+
+	fsys = scanner.LocalFS{}
+	for path, want := range map[string]bool{
+		"/srv/www/index.php":        true,
+		"/srv/www/a.zip::index.php": false,
+		STDIN_NAME:                  false,
+	} {
+		if got := localFile(path); got != want {
+			t.Errorf("localFile(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	fsys = &sftpFS{}
+	if localFile("/srv/www/index.php") {
+		t.Error("a file on the SFTP server is local")
+	}
+}
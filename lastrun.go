@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// readLastRun returns the time stored in the last-run file.
+// A missing file means there was no previous run and yields a zero time.
+func readLastRun(path string) (time.Time, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: malformed timestamp", path)
+	}
+	return t, nil
+}
+
+// saveLastRun stores the start time of the run if the scan completed.
+// After a scan stopped early the previous time is kept, so the next run
+// still checks the files this one never reached.
+func saveLastRun(path string, t time.Time, complete bool) error {
+	if !complete {
+		return nil
+	}
+	return writeLastRun(path, t)
+}
+
+// writeLastRun atomically stores the time in the last-run file.
+func writeLastRun(path string, t time.Time) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".rigel-lastrun-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := fmt.Fprintln(tmp, t.Format(time.RFC3339Nano)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveLastRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lastrun")
+	first := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	if err := saveLastRun(path, first, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readLastRun(path); err != nil || !got.Equal(first) {
+		t.Fatalf("complete scan: got %s, %v, want %s", got, err, first)
+	}
+
+	// An interrupted or capped scan keeps the time of the previous one
+	if err := saveLastRun(path, second, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readLastRun(path); !got.Equal(first) {
+		t.Errorf("interrupted scan: got %s, want %s", got, first)
+	}
+
+	// Nor is the file created by an interrupted first run
+	missing := filepath.Join(t.TempDir(), "lastrun")
+	saveLastRun(missing, second, false)
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("interrupted first run: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logRecord is a diagnostic message in JSON mode.
+type logRecord struct {
+	Kind    string `json:"kind"`
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Reason  Reason `json:"reason,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// jsonLog turns the lines written by the log package into JSON records,
+// one per line. The level is taken from the "[level]" prefix of the message.
+type jsonLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (j *jsonLog) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	level := "info"
+	if strings.HasPrefix(line, "[") {
+		if i := strings.Index(line, "] "); i > 0 {
+			level, line = line[1:i], line[i+2:]
+		}
+	}
+	if err := j.write(logRecord{Level: level, Message: line}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (j *jsonLog) write(rec logRecord) error {
+	rec.Kind = "log"
+	rec.Time = time.Now().Format(time.RFC3339)
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, err = fmt.Fprintf(j.w, "%s\n", b)
+	return err
+}
+
+// jsonLogger is the log output in JSON mode, nil otherwise.
+var jsonLogger *jsonLog
+
+// setLogOutput directs the diagnostics of logLevel and above to w,
+// as JSON records in JSON mode.
+func setLogOutput(w io.Writer) {
+	if FORMAT == FORMAT_JSON {
+		log.SetFlags(0)
+		jsonLogger = &jsonLog{w: w}
+		w = jsonLogger
+	}
+	log.SetOutput(levelFilter{w})
+}
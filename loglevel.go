@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+)
+
+// Diagnostic levels. The messages written with the log package
+// carry their level as the "[level]" prefix.
+const (
+	LOG_TRACE = iota
+	LOG_DEBUG
+	LOG_INFO
+	LOG_WARNING
+	LOG_FATAL
+)
+
+var logLevelNames = map[string]int{
+	"trace":   LOG_TRACE,
+	"debug":   LOG_DEBUG,
+	"info":    LOG_INFO,
+	"warning": LOG_WARNING,
+	"fatal":   LOG_FATAL,
+}
+
+// logLevel is the lowest level written, set by -log-level,
+// lowered by -vv and raised by -quiet.
+var logLevel = LOG_INFO
+
+func logLevelName(level int) string {
+	for name, l := range logLevelNames {
+		if l == level {
+			return name
+		}
+	}
+	return ""
+}
+
+// levelOf returns the level of a log line by its "[level]" prefix,
+// which must directly follow the date and time the log flags add.
+// Other lines are info, even if a "[level]" appears later in them.
+func levelOf(line []byte) int {
+	flags := log.Flags()
+	if flags&log.Ldate != 0 {
+		line = skipField(line)
+	}
+	if flags&(log.Ltime|log.Lmicroseconds) != 0 {
+		line = skipField(line)
+	}
+	if len(line) == 0 || line[0] != '[' {
+		return LOG_INFO
+	}
+	j := bytes.IndexByte(line, ']')
+	if j < 0 {
+		return LOG_INFO
+	}
+	if level, ok := logLevelNames[string(line[1:j])]; ok {
+		return level
+	}
+	return LOG_INFO
+}
+
+// skipField drops the head of the line up to and including the first space.
+func skipField(line []byte) []byte {
+	if i := bytes.IndexByte(line, ' '); i >= 0 {
+		return line[i+1:]
+	}
+	return nil
+}
+
+// levelFilter drops the log lines below logLevel. The log package
+// writes each message with a single serialized call, so the lines
+// are filtered whole and never interleave.
+type levelFilter struct {
+	w io.Writer
+}
+
+func (f levelFilter) Write(p []byte) (int, error) {
+	if levelOf(p) < logLevel {
+		return len(p), nil
+	}
+	return f.w.Write(p)
+}
+
+// logf writes a message with the level prefix. Nothing is formatted
+// if the level is not shown, since the trace messages are per file.
+func logf(level int, format string, v ...interface{}) {
+	if level < logLevel {
+		return
+	}
+	if name := logLevelName(level); len(name) > 0 {
+		log.Printf("[%s] %s\n", name, fmt.Sprintf(format, v...))
+	}
+}
+
+// skipped reports at the trace level why a file is not scanned.
+func skipped(reason Reason, why string, path string) {
+	if logLevel > LOG_TRACE {
+		return
+	}
+	if jsonLogger != nil {
+		jsonLogger.write(logRecord{Level: "trace", Reason: reason, Path: path, Message: why})
+		return
+	}
+	log.Printf("[trace] %s: %s: %s\n", reason, why, path)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestLevelOf(t *testing.T) {
+	saved := log.Flags()
+	defer log.SetFlags(saved)
+
+	tests := []struct {
+		flags int
+		line  string
+		want  int
+	}{
+		{log.LstdFlags, "2026/10/15 07:52:02 [warning] cannot read: x.php\n", LOG_WARNING},
+		{log.LstdFlags, "2026/10/15 07:52:02 [trace] skip_binary: not scanned: a.png\n", LOG_TRACE},
+		{log.LstdFlags | log.Lmicroseconds, "2026/10/15 07:52:02.123456 [debug] db.xml: 2 signatures\n", LOG_DEBUG},
+		{log.Ltime, "07:52:02 [fatal] stop\n", LOG_FATAL},
+		{0, "[warning] cannot read: x.php\n", LOG_WARNING},
+		// A bracketed level later in the message is not the level
+		{log.LstdFlags, "2026/10/15 07:52:02 cannot read: /srv/[trace]/x.php\n", LOG_INFO},
+		{0, "file /srv/[debug] is large\n", LOG_INFO},
+		{log.LstdFlags, "2026/10/15 07:52:02 [unknown] message\n", LOG_INFO},
+		{log.LstdFlags, "2026/10/15\n", LOG_INFO},
+	}
+	for _, tt := range tests {
+		log.SetFlags(tt.flags)
+		if got := levelOf([]byte(tt.line)); got != tt.want {
+			t.Errorf("levelOf(%q) = %d, want %d", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	savedLevel, savedFlags := logLevel, log.Flags()
+	defer func() {
+		logLevel = savedLevel
+		log.SetFlags(savedFlags)
+	}()
+	log.SetFlags(log.LstdFlags)
+
+	var b bytes.Buffer
+	f := levelFilter{&b}
+	logLevel = LOG_WARNING
+	f.Write([]byte("2026/10/15 07:52:02 [debug] hidden /srv/[warning]/x\n"))
+	f.Write([]byte("2026/10/15 07:52:02 [warning] shown\n"))
+	if got, want := b.String(), "2026/10/15 07:52:02 [warning] shown\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
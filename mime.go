@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// checkHead reads the head of the file to skip the empty files and the
+// content types that are not scanned, and rewinds the file. A short
+// read is not mistaken for the whole content.
+func checkHead(scn *scanner.Scanner, f io.ReadSeeker, name string) Reason {
+	head := make([]byte, scanner.MIME_SNIFF_LEN)
+	n, err := io.ReadFull(f, head)
+	switch {
+	case n == 0 && err == io.EOF:
+		skipped(SKIP_EMPTY, "file is empty", name)
+		return SKIP_EMPTY
+	case err != nil && err != io.EOF && err != io.ErrUnexpectedEOF:
+		reason := errReason(err, ERR_READ)
+		warning(reason, err, name)
+		return reason
+	}
+	if scn.CheckContentType() {
+		if mimeType := scanner.DetectContentType(head[:n]); !scn.AcceptContentType(mimeType) {
+			skipped(SKIP_BINARY, fmt.Sprintf("content type %s is not scanned", mimeType), name)
+			return SKIP_BINARY
+		}
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		warning(ERR_READ, err, name)
+		return ERR_READ
+	}
+	return ""
+}
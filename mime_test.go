@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+var testHeads = map[string]string{
+	"bom":       "\xef\xbb\xbf<?php eval($_POST['c']);",
+	"nul-php":   "\x00\x00<?php eval($_POST['c']);",
+	"nul-echo":  "\x00<?= $_GET['c'] ?>",
+	"nul":       "\x00\x01\x02\x03binary data",
+	"php":       "<?php echo 'hello';",
+	"xml":       `<?xml version="1.0"?><config/>`,
+	"png-php":   "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR<?php system($_GET['c']); ?>",
+	"png":       "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x01\x00",
+	"empty":     "",
+	"plaintext": "just some text\n",
+}
+
+// testFilters returns a scanner with -filter and -mime set.
+func testFilters(t *testing.T, filter string, mime string) *scanner.Scanner {
+	scn := &scanner.Scanner{}
+	if len(filter) > 0 {
+		ext := make(FileExtensions)
+		if err := ext.Set(filter); err != nil {
+			t.Fatal(err)
+		}
+		scn.Extensions = ext
+	}
+	if len(mime) > 0 {
+		scn.ContentTypes.Set(mime)
+	}
+	return scn
+}
+
+// fileDecision returns the reason the file would be skipped for,
+// by the walk filters and by its head, or "" if it is scanned.
+func fileDecision(t *testing.T, scn *scanner.Scanner, name, content string) Reason {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := acceptFile(scn, path, info, nil); !ok {
+		return SKIP_FILTERED
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return checkHead(scn, f, name)
+}
+
+func TestCheckHead(t *testing.T) {
+	tests := []struct {
+		filter string
+		mime   string
+		name   string
+		head   string
+		want   Reason
+	}{
+		// Content types only, the default ones
+		{"", "", "a.php", "bom", ""},
+		{"", "", "a.php", "nul-php", ""},
+		{"", "", "a.php", "nul-echo", ""},
+		{"", "", "a.php", "nul", SKIP_BINARY},
+		{"", "", "a.php", "php", ""},
+		{"", "", "a.xml", "xml", ""},
+		{"", "", "a.png", "png-php", ""},
+		{"", "", "a.png", "png", SKIP_BINARY},
+		{"", "", "a.php", "empty", SKIP_EMPTY},
+
+		// Extensions only, the content type is not checked
+		{"php", "", "a.php", "nul", ""},
+		{"php", "", "a.inc", "php", SKIP_FILTERED},
+		{"php", "", "a.php", "empty", SKIP_EMPTY},
+
+		// Both must pass
+		{"php", "text/", "a.php", "php", ""},
+		{"php", "text/", "a.php", "nul", SKIP_BINARY},
+		{"php", "text/", "a.inc", "php", SKIP_FILTERED},
+		{"php", "text/", "a.php", "bom", ""},
+		{"php", "text/", "a.php", "empty", SKIP_EMPTY},
+
+		// -mime replaces the default types
+		{"", "application/octet-stream", "a.bin", "nul", ""},
+		{"", "application/octet-stream", "a.php", "php", SKIP_BINARY},
+		{"", "text/x-php", "a.php", "nul-php", ""},
+		{"", "text/x-php", "a.txt", "plaintext", SKIP_BINARY},
+		{"", "/xml", "a.xml", "xml", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter+"|"+tt.mime+"|"+tt.name+"|"+tt.head, func(t *testing.T) {
+			scn := testFilters(t, tt.filter, tt.mime)
+			if got := fileDecision(t, scn, tt.name, testHeads[tt.head]); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// readSeeker combines a reader returning short reads with a seeker.
+type readSeeker struct {
+	io.Reader
+	io.Seeker
+}
+
+func TestCheckHeadShortReads(t *testing.T) {
+	scn := testFilters(t, "", "")
+
+	r := bytes.NewReader([]byte("\x00\x00<?php eval($_POST['c']);"))
+	if got := checkHead(scn, readSeeker{iotest.OneByteReader(r), r}, "short.php"); got != "" {
+		t.Errorf("one byte reads: got %q, want the file to be scanned", got)
+	}
+	if pos, _ := r.Seek(0, io.SeekCurrent); pos != 0 {
+		t.Errorf("the file is not rewound, at %d", pos)
+	}
+
+	r = bytes.NewReader(nil)
+	if got := checkHead(scn, readSeeker{iotest.ErrReader(io.ErrClosedPipe), r}, "broken.php"); got != ERR_READ {
+		t.Errorf("read error: got %q, want %q", got, ERR_READ)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+type flusher interface {
+	Flush() error
+}
+
+// gzipFile is a gzip-compressed output file.
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+// Flush completes the current compressed block, so all data written
+// so far can be decompressed even if the process is interrupted later.
+func (g *gzipFile) Flush() error {
+	return g.Writer.Flush()
+}
+
+func (g *gzipFile) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.f.Close()
+		return err
+	}
+	return g.f.Close()
+}
+
+// createOutput creates the results file. Files with the ".gz" extension
+// are compressed with gzip.
+func createOutput(path string) (io.WriteCloser, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".gz") {
+		return &gzipFile{gzip.NewWriter(f), f}, nil
+	}
+	return f, nil
+}
+
+// HELD_MAXBYTES limits the diagnostics held back by -only-on-match.
+// The oldest lines are dropped beyond it, so a long scan of a broken
+// tree cannot take all the memory.
+const HELD_MAXBYTES = 1024 * 1024 // 1M
+
+// heldWriter keeps the last HELD_MAXBYTES written to it in memory
+// until it is released or discarded.
+type heldWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	w   io.Writer
+
+	// The lines dropped from the head of buf
+	droppedLines int
+	droppedBytes int
+}
+
+func (h *heldWriter) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n, err := h.buf.Write(p)
+	if excess := h.buf.Len() - HELD_MAXBYTES; excess > 0 {
+		// Whole lines are dropped, the log package writes one per call
+		b := h.buf.Bytes()
+		cut := len(b)
+		if i := bytes.IndexByte(b[excess-1:], '\n'); i >= 0 {
+			cut = excess + i
+		}
+		h.droppedLines += bytes.Count(b[:cut], []byte{'\n'})
+		h.droppedBytes += cut
+		h.buf.Next(cut)
+	}
+	return n, err
+}
+
+// Release writes the held data to the underlying writer
+// if emit is true and discards it otherwise. A notice
+// of the dropped lines, if any, goes first.
+func (h *heldWriter) Release(emit bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	defer h.buf.Reset()
+	if !emit {
+		return nil
+	}
+	if h.droppedLines > 0 {
+		msg := fmt.Sprintf("%d earlier lines (%d bytes) of diagnostics were dropped, only the last %d bytes were kept", h.droppedLines, h.droppedBytes, HELD_MAXBYTES)
+		if jsonLogger != nil {
+			(&jsonLog{w: h.w}).write(logRecord{Level: "warning", Message: msg})
+		} else {
+			fmt.Fprintf(h.w, "[warning] %s\n", msg)
+		}
+	}
+	_, err := h.buf.WriteTo(h.w)
+	return err
+}
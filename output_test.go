@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func TestGzipOutputFlushed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "matches.jsonl.gz")
+	out, err := createOutput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	if _, ok := out.(*gzipFile); !ok {
+		t.Fatalf("output is %T, want a gzip file", out)
+	}
+	r := NewReporter(out, FORMAT_JSON, true)
+	if err := r.Report(scanner.Match{Path: "site/a.php", Id: 1, Title: "eval-post"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The record is readable before the output is closed,
+	// as after a crash
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(zr)
+	if !strings.Contains(string(got), `"path":"site/a.php"`) {
+		t.Errorf("got %q, want the record", got)
+	}
+}
+
+func TestHeldWriterCap(t *testing.T) {
+	var out bytes.Buffer
+	h := &heldWriter{w: &out}
+
+	line := strings.Repeat("x", 99) + "\n"
+	n := HELD_MAXBYTES/len(line) + 50
+	for i := 0; i < n; i++ {
+		h.Write([]byte(line))
+	}
+	h.Write([]byte("last\n"))
+
+	if h.buf.Len() > HELD_MAXBYTES {
+		t.Errorf("%d bytes held, want at most %d", h.buf.Len(), HELD_MAXBYTES)
+	}
+	if err := h.Release(true); err != nil {
+		t.Fatal(err)
+	}
+
+	got := out.String()
+	first, rest, _ := strings.Cut(got, "\n")
+	if !strings.HasPrefix(first, "[warning] ") || !strings.Contains(first, "dropped") {
+		t.Errorf("no notice of the dropped lines: %q", first)
+	}
+	if !strings.HasPrefix(rest, line) || !strings.HasSuffix(rest, line+"last\n") {
+		t.Error("the kept lines are not whole or not the last ones")
+	}
+	if kept := strings.Count(rest, "\n"); kept+h.droppedLines != n+1 {
+		t.Errorf("%d lines kept and %d dropped, want %d in total", kept, h.droppedLines, n+1)
+	}
+}
+
+func TestHeldWriterDiscard(t *testing.T) {
+	var out bytes.Buffer
+	h := &heldWriter{w: &out}
+	h.Write([]byte("[warning] something\n"))
+	h.Release(false)
+	if out.Len() > 0 {
+		t.Errorf("discarded output written: %q", out.String())
+	}
+}
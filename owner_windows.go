@@ -0,0 +1,9 @@
+package main
+
+import (
+	"os"
+)
+
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Intervals between the progress updates on a terminal
+// and in a log file, where each update takes a line.
+const (
+	PROGRESS_TTY_INTERVAL  = 500 * time.Millisecond
+	PROGRESS_LINE_INTERVAL = 10 * time.Second
+)
+
+// Progress prints the number of files scanned so far and the scan rate
+// to stderr. On a terminal the status line is updated in place.
+type Progress struct {
+	f      *os.File
+	tty    bool
+	asJSON bool
+	start  time.Time
+	done   chan struct{}
+	exited chan struct{}
+}
+
+// StartProgress starts printing the progress of the scan
+// until Stop is called.
+func StartProgress(f *os.File, asJSON bool) *Progress {
+	p := &Progress{
+		f:      f,
+		tty:    isTerminal(f) && !asJSON,
+		asJSON: asJSON,
+		start:  time.Now(),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	interval := PROGRESS_LINE_INTERVAL
+	if p.tty {
+		interval = PROGRESS_TTY_INTERVAL
+	}
+	go p.run(interval)
+	return p
+}
+
+func (p *Progress) run(interval time.Duration) {
+	defer close(p.exited)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			if p.tty {
+				// Clear the status line before the summary
+				fmt.Fprint(p.f, "\r\033[K")
+			}
+			return
+		case <-t.C:
+			p.print()
+		}
+	}
+}
+
+func (p *Progress) print() {
+	elapsed := time.Since(p.start)
+	scanned := atomic.LoadInt64(&stats.Scanned)
+	bytes := atomic.LoadInt64(&stats.Bytes)
+	matches := atomic.LoadInt64(&totalMatches)
+	rate := float64(scanned) / elapsed.Seconds()
+
+	if p.asJSON {
+		b, _ := json.Marshal(struct {
+			Progress interface{} `json:"progress"`
+		}{struct {
+			Scanned int64   `json:"scanned"`
+			Bytes   int64   `json:"bytes_read"`
+			Matches int64   `json:"matches"`
+			Rate    float64 `json:"files_per_second"`
+			Elapsed float64 `json:"elapsed_seconds"`
+		}{scanned, bytes, matches, rate, elapsed.Seconds()}})
+		fmt.Fprintf(p.f, "%s\n", b)
+		return
+	}
+
+	line := fmt.Sprintf("scanned %d files (%.1f files/s, %.1fM read), %d matches, %s",
+		scanned, rate, float64(bytes)/(1<<20), matches, elapsed.Round(time.Second))
+	if p.tty {
+		fmt.Fprintf(p.f, "\r\033[K%s", line)
+	} else {
+		fmt.Fprintf(p.f, "[progress] %s\n", line)
+	}
+}
+
+// Stop stops the updates and clears the status line.
+func (p *Progress) Stop() {
+	close(p.done)
+	<-p.exited
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// QUARANTINE_MANIFEST is the file in the quarantine directory
+// that records where the quarantined files came from.
+const QUARANTINE_MANIFEST = "manifest.jsonl"
+
+// DEFAULT_QUARANTINE is the quarantine directory
+// of the interactive mode if -quarantine is not given.
+const DEFAULT_QUARANTINE = "rigel-quarantine"
+
+// Quarantine moves matched files into a directory and records their
+// original location, permissions and owner in the manifest, one JSON
+// object per line, so that they can be restored later.
+type Quarantine struct {
+	Dir string
+
+	// Move the matched files as they are found, otherwise
+	// only the files chosen in the interactive mode are moved
+	Auto bool
+
+	// Also quarantine the files that only matched soft and info signatures
+	Soft bool
+
+	// The files are placed under Dir by their path relative to the root
+	// they were found in, or by their absolute path without roots
+	Roots []string
+
+	mu sync.Mutex
+}
+
+type quarantineRecord struct {
+	Time        time.Time `json:"time"`
+	Original    string    `json:"original"`
+	Quarantined string    `json:"quarantined"`
+	Mode        string    `json:"mode"`
+	Uid         *int      `json:"uid,omitempty"`
+	Gid         *int      `json:"gid,omitempty"`
+	Signatures  []int     `json:"signatures,omitempty"`
+}
+
+// Wants reports whether a file with these matches is quarantined.
+// The signatures of an unknown severity count as critical ones.
+func (q *Quarantine) Wants(ms []scanner.Match) bool {
+	for _, m := range ms {
+		if m.Type == scanner.SEVERITY_CRITICAL || m.Type == scanner.SEVERITY_UNKNOWN || q.Soft {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDir reports whether the path is the quarantine directory itself,
+// so the walk does not scan the quarantined files again.
+func (q *Quarantine) IsDir(path string) bool {
+	a, err1 := filepath.Abs(path)
+	b, err2 := filepath.Abs(q.Dir)
+	return err1 == nil && err2 == nil && a == b
+}
+
+// Move moves the file into the quarantine directory and returns
+// its new path. An existing file there is never overwritten,
+// a numeric suffix is appended to the name instead.
+func (q *Quarantine) Move(path string, ms []scanner.Match) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	// The roots are compared in the absolute form, so that the relative
+	// paths walked from the default rootdir "." are found under it
+	var roots []string
+	for _, r := range q.Roots {
+		if r, err := filepath.Abs(r); err == nil {
+			roots = append(roots, r)
+		}
+	}
+	rel := abs
+	if root := rootOf(roots, abs); len(root) > 0 {
+		if r, err := filepath.Rel(root, abs); err == nil {
+			rel = r
+		}
+	}
+	info, err := os.Lstat(abs)
+	if err != nil {
+		return "", err
+	}
+
+	// The destination is picked and the manifest is written
+	// by one worker at a time
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dst := filepath.Join(q.Dir, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return "", err
+	}
+	for i, base := 1, dst; ; i++ {
+		if _, err := os.Lstat(dst); os.IsNotExist(err) {
+			break
+		}
+		dst = fmt.Sprintf("%s.%d", base, i)
+	}
+
+	if err := moveFile(abs, dst, info); err != nil {
+		return "", err
+	}
+
+	rec := quarantineRecord{
+		Time:        time.Now(),
+		Original:    abs,
+		Quarantined: dst,
+		Mode:        fmt.Sprintf("%#o", info.Mode().Perm()),
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		rec.Uid, rec.Gid = &uid, &gid
+	}
+	for _, m := range ms {
+		rec.Signatures = append(rec.Signatures, m.Id)
+	}
+	if err := q.record(rec); err != nil {
+		return dst, fmt.Errorf("moved to %s, but cannot update the manifest: %s", dst, err)
+	}
+	return dst, nil
+}
+
+func (q *Quarantine) record(rec quarantineRecord) error {
+	f, err := os.OpenFile(filepath.Join(q.Dir, QUARANTINE_MANIFEST), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// moveFile renames the file, or copies and removes it
+// if the destination is on another device.
+func moveFile(src, dst string, info os.FileInfo) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	// The owner is kept if possible, the manifest has it anyway
+	if uid, gid, ok := fileOwner(info); ok {
+		os.Lchown(dst, uid, gid)
+	}
+	os.Chtimes(dst, info.ModTime(), info.ModTime())
+
+	return os.Remove(src)
+}
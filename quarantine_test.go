@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func TestQuarantineWants(t *testing.T) {
+	tests := []struct {
+		types []scanner.Severity
+		soft  bool
+		want  bool
+	}{
+		{nil, true, false},
+		{[]scanner.Severity{scanner.SEVERITY_CRITICAL}, false, true},
+		{[]scanner.Severity{scanner.SEVERITY_UNKNOWN}, false, true},
+		{[]scanner.Severity{scanner.SEVERITY_SOFT}, false, false},
+		{[]scanner.Severity{scanner.SEVERITY_INFO}, false, false},
+		{[]scanner.Severity{scanner.SEVERITY_SOFT, scanner.SEVERITY_CRITICAL}, false, true},
+		{[]scanner.Severity{scanner.SEVERITY_SOFT}, true, true},
+		{[]scanner.Severity{scanner.SEVERITY_INFO}, true, true},
+	}
+	for _, tt := range tests {
+		var ms []scanner.Match
+		for _, sever := range tt.types {
+			ms = append(ms, scanner.Match{Type: sever})
+		}
+		q := &Quarantine{Soft: tt.soft}
+		if got := q.Wants(ms); got != tt.want {
+			t.Errorf("%v, soft %v: got %v, want %v", tt.types, tt.soft, got, tt.want)
+		}
+	}
+}
+
+func TestQuarantineMove(t *testing.T) {
+	dir := t.TempDir()
+	qdir := filepath.Join(t.TempDir(), "quarantine")
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	q := &Quarantine{Dir: qdir, Roots: []string{"."}}
+	ms := []scanner.Match{{Id: 7, Type: scanner.SEVERITY_CRITICAL}}
+
+	// The paths walked from the default rootdir are relative,
+	// a file found later under an absolute path lands next to them
+	for i, path := range []string{"sub/x.php", filepath.Join(dir, "sub", "x.php")} {
+		if err := os.WriteFile(filepath.Join(dir, "sub", "x.php"), []byte("<?php"), 0640); err != nil {
+			t.Fatal(err)
+		}
+		dst, err := q.Move(path, ms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := filepath.Join(qdir, "sub", "x.php")
+		if i > 0 {
+			want += ".1"
+		}
+		if dst != want {
+			t.Errorf("%s: moved to %s, want %s", path, dst, want)
+		}
+		if _, err := os.Stat(dst); err != nil {
+			t.Errorf("%s: %s", path, err)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, "sub", "x.php")); !os.IsNotExist(err) {
+			t.Errorf("%s: the original is still there", path)
+		}
+	}
+
+	// Outside the roots the absolute path is kept
+	other := filepath.Join(t.TempDir(), "y.php")
+	if err := os.WriteFile(other, []byte("<?php"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q.Roots = []string{"sub"}
+	dst, err := q.Move(other, ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(qdir, other); dst != want {
+		t.Errorf("outside the roots: moved to %s, want %s", dst, want)
+	}
+
+	f, err := os.Open(filepath.Join(qdir, QUARANTINE_MANIFEST))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var recs []quarantineRecord
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var rec quarantineRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("%d manifest records, want 3", len(recs))
+	}
+	if recs[0].Original != filepath.Join(dir, "sub", "x.php") || recs[0].Mode != "0640" || len(recs[0].Signatures) != 1 {
+		t.Errorf("manifest record %+v", recs[0])
+	}
+}
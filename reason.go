@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// Reason is a stable machine-readable code that explains
+// why a file was skipped or could not be scanned.
+type Reason string
+
+const (
+	SKIP_FILTERED    Reason = "SKIP_FILTERED"    // rejected by the extension filter
+	SKIP_BINARY      Reason = "SKIP_BINARY"      // content type is not text
+	SKIP_TOO_LARGE   Reason = "SKIP_TOO_LARGE"   // file size exceeds the limit
+	SKIP_UNCHANGED   Reason = "SKIP_UNCHANGED"   // not modified since the given time
+	SKIP_ALLOWED     Reason = "SKIP_ALLOWED"     // content digest is in the allowlist
+	SKIP_BROKEN      Reason = "SKIP_BROKEN"      // symlink target does not exist
+	SKIP_EMPTY       Reason = "SKIP_EMPTY"       // file has no content
+	SKIP_INTERRUPTED Reason = "SKIP_INTERRUPTED" // scan stopped before the file was scanned completely
+
+	ERR_PERMISSION Reason = "ERR_PERMISSION" // permission denied
+	ERR_NOT_FOUND  Reason = "ERR_NOT_FOUND"  // file disappeared or never existed
+	ERR_READ       Reason = "ERR_READ"       // any other I/O error
+	ERR_WALK       Reason = "ERR_WALK"       // directory traversal error
+	ERR_DECODER    Reason = "ERR_DECODER"    // external decoder failed
+	ERR_QUARANTINE Reason = "ERR_QUARANTINE" // matched file could not be quarantined
+)
+
+// errReason classifies an I/O error into one of the ERR_* codes.
+// The fallback code is used when the error has no specific class.
+func errReason(err error, fallback Reason) Reason {
+	switch {
+	case os.IsPermission(err):
+		return ERR_PERMISSION
+	case os.IsNotExist(err):
+		return ERR_NOT_FOUND
+	}
+	return fallback
+}
+
+// warning reports a skipped or failed path with its reason code.
+func warning(reason Reason, msg interface{}, path string) {
+	if logLevel > LOG_WARNING {
+		return
+	}
+	if jsonLogger != nil {
+		jsonLogger.write(logRecord{Level: "warning", Reason: reason, Path: path, Message: fmt.Sprint(msg)})
+		return
+	}
+	log.Printf("[warning] %s: %s: %s\n", reason, msg, path)
+}
+
+// fatal reports an error that makes the scan impossible
+// and exits with EXIT_FATAL. The held diagnostics are released first.
+// With -log-file the error is printed to stderr as well.
+func fatal(v ...interface{}) {
+	log.Println(append([]interface{}{"[fatal]"}, v...)...)
+	if logFile != nil {
+		fmt.Fprintln(os.Stderr, append([]interface{}{"[fatal]"}, v...)...)
+	}
+	if h, ok := diag.(*heldWriter); ok {
+		h.Release(true)
+	}
+	os.Exit(EXIT_FATAL)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// Output formats
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+	FORMAT_CSV  = "csv"
+)
+
+// Columns of the CSV output
+var csvHeader = []string{"time", "path", "id", "title", "type", "offset", "length", "decoders", "embedded_at", "entropy", "sha256", "source", "line", "raw_offset", "context"}
+
+// Orders of the collected matches
+const (
+	SORT_PATH     = "path"
+	SORT_SEVERITY = "severity"
+)
+
+// sortMatches orders the matches by path, or by severity (worst first)
+// and then by path. Matches within a file keep their offset order.
+func sortMatches(ms []scanner.Match, by string) {
+	sort.SliceStable(ms, func(i, j int) bool {
+		if by == SORT_SEVERITY {
+			if ms[i].Type != ms[j].Type {
+				return ms[i].Type > ms[j].Type
+			}
+		}
+		if ms[i].Path != ms[j].Path {
+			return ms[i].Path < ms[j].Path
+		}
+		return ms[i].Offset < ms[j].Offset
+	})
+}
+
+// Reporter serializes the output of matches from concurrent workers.
+type Reporter struct {
+	// Verbose adds the length of the matched region to the text output
+	Verbose bool
+
+	// ShowSource adds the database the signature comes from to the text
+	// output, set when more than one is loaded. Verbose always adds it
+	ShowSource bool
+
+	// ShowContext adds the position and the snippet of the match,
+	// they are left out otherwise even if the scanner computed them
+	ShowContext bool
+
+	// If set, the matches are collected and written sorted
+	// by SORT_PATH or SORT_SEVERITY on Flush
+	Sort string
+	held []scanner.Match
+
+	mu     sync.Mutex
+	out    io.Writer
+	w      *bufio.Writer
+	csv    *csv.Writer
+	header bool
+	format string
+	stream bool
+}
+
+// NewReporter returns a reporter writing to w in the given format.
+// In JSON mode every match is written as a single JSON object per line
+// (not as an array, so the output can be consumed while the scan runs).
+// In CSV mode the first line is the header, written with the first
+// record, so a scan without matches writes nothing. If stream is true,
+// the writer is flushed after each record, so a reader gets the matches
+// as soon as they are found at the cost of one write call per record.
+func NewReporter(w io.Writer, format string, stream bool) *Reporter {
+	r := &Reporter{
+		out:    w,
+		w:      bufio.NewWriter(w),
+		format: format,
+		stream: stream,
+	}
+	if format == FORMAT_CSV {
+		r.csv = csv.NewWriter(r.w)
+	}
+	return r
+}
+
+// Report writes the matches of a single file. They are written
+// together, so the matches of different files never interleave.
+func (r *Reporter) Report(ms ...scanner.Match) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.Sort) > 0 {
+		r.held = append(r.held, ms...)
+		return nil
+	}
+
+	for _, m := range ms {
+		if err := r.write(m); err != nil {
+			return err
+		}
+	}
+	if r.stream {
+		return r.flush()
+	}
+	return nil
+}
+
+func (r *Reporter) write(m scanner.Match) error {
+	if !r.ShowContext {
+		m.Line, m.RawOffset, m.Context = 0, nil, ""
+	}
+
+	switch r.format {
+	case FORMAT_JSON:
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		r.w.Write(b)
+		r.w.WriteByte('\n')
+	case FORMAT_CSV:
+		if !r.header {
+			r.csv.Write(csvHeader)
+			r.header = true
+		}
+		var embedded, entropy, line, rawOffset string
+		if m.Line > 0 {
+			line = strconv.Itoa(m.Line)
+		}
+		if m.RawOffset != nil {
+			rawOffset = strconv.Itoa(*m.RawOffset)
+		}
+		if m.Embedded != nil {
+			embedded = strconv.Itoa(*m.Embedded)
+		}
+		if m.Entropy != nil {
+			entropy = strconv.FormatFloat(*m.Entropy, 'f', 2, 64)
+		}
+		r.csv.Write([]string{
+			m.Time.Format(time.RFC3339),
+			m.Path,
+			strconv.Itoa(m.Id),
+			m.Title,
+			m.Type.String(),
+			strconv.Itoa(m.Offset),
+			strconv.Itoa(m.Length),
+			strings.Join(m.Decoders, ";"),
+			embedded,
+			entropy,
+			m.SHA256,
+			m.Source,
+			line,
+			rawOffset,
+			m.Context,
+		})
+		// The csv writer has its own buffer on top of r.w
+		r.csv.Flush()
+		return r.csv.Error()
+	default:
+		fmt.Fprintf(r.w, "Matched: %s (signature id = %d, severity = %s): %s", m.Title, m.Id, m.Type, m.Path)
+		if r.Verbose {
+			fmt.Fprintf(r.w, " [%d bytes at offset %d]", m.Length, m.Offset)
+		}
+		if (r.Verbose || r.ShowSource) && len(m.Source) > 0 {
+			fmt.Fprintf(r.w, " [database %s]", m.Source)
+		}
+		if m.Embedded != nil {
+			fmt.Fprintf(r.w, " [embedded at offset %d]", *m.Embedded)
+		}
+		if m.Entropy != nil {
+			fmt.Fprintf(r.w, " [entropy %.2f]", *m.Entropy)
+		}
+		if len(m.SHA256) > 0 {
+			fmt.Fprintf(r.w, " [sha256 %s]", m.SHA256)
+		}
+		if r.ShowContext {
+			fmt.Fprintf(r.w, " [line %d, offset %d", m.Line, m.Offset)
+			if m.RawOffset != nil {
+				fmt.Fprintf(r.w, ", file offset %d", *m.RawOffset)
+			}
+			r.w.WriteString("]\n")
+			for _, l := range strings.Split(m.Context, "\n") {
+				fmt.Fprintf(r.w, "    | %s\n", l)
+			}
+			break
+		}
+		r.w.WriteByte('\n')
+	}
+	return nil
+}
+
+// Flush writes any buffered records to the underlying writer.
+// In the sorted mode the collected matches are written at this point.
+func (r *Reporter) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.held) > 0 {
+		sortMatches(r.held, r.Sort)
+		for _, m := range r.held {
+			if err := r.write(m); err != nil {
+				return err
+			}
+		}
+		r.held = nil
+	}
+
+	return r.flush()
+}
+
+func (r *Reporter) flush() error {
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	if f, ok := r.out.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
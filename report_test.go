@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func TestReportTextSource(t *testing.T) {
+	m := scanner.Match{Id: 1, Title: "eval-post", Type: scanner.SEVERITY_CRITICAL, Path: "a.php", Source: "extra.xml"}
+
+	tests := []struct {
+		verbose    bool
+		showSource bool
+		want       bool
+	}{
+		{false, false, false},
+		{false, true, true},
+		{true, false, true},
+		{true, true, true},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		r := NewReporter(&b, FORMAT_TEXT, true)
+		r.Verbose = tt.verbose
+		r.ShowSource = tt.showSource
+		if err := r.Report(m); err != nil {
+			t.Fatal(err)
+		}
+		r.Flush()
+		if got := strings.Contains(b.String(), "[database extra.xml]"); got != tt.want {
+			t.Errorf("verbose %v, source %v: %q", tt.verbose, tt.showSource, b.String())
+		}
+		if n := strings.Count(b.String(), "[database "); n > 1 {
+			t.Errorf("verbose %v, source %v: the source is repeated: %q", tt.verbose, tt.showSource, b.String())
+		}
+	}
+}
+
+func TestReportCSVHeader(t *testing.T) {
+	var b bytes.Buffer
+	r := NewReporter(&b, FORMAT_CSV, true)
+	r.Flush()
+	if b.Len() != 0 {
+		t.Errorf("no matches: got %q, want no output", b.String())
+	}
+
+	m := scanner.Match{Id: 1, Title: "eval-post", Type: scanner.SEVERITY_CRITICAL, Path: "a.php"}
+	r.Report(m)
+	r.Report(m)
+	r.Flush()
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 || lines[0] != strings.Join(csvHeader, ",") {
+		t.Errorf("two matches: got %q, want the header and two records", b.String())
+	}
+}
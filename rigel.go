@@ -1,38 +1,24 @@
 package main
 
 import (
-	"encoding/xml"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
 	"runtime"
-	"strconv"
 	"strings"
 	"sync"
-)
+	"sync/atomic"
+	"time"
 
-const (
-	READER_BLOCKSIZE = 512 * 1024      // 512K
-	MAXFILESIZE      = 2 * 1024 * 1024 // 2M
+	"github.com/0xef53/rigel/scanner"
 )
 
-type Database struct {
-	Signatures []Signature `xml:"signature"`
-}
-
-type Signature struct {
-	Id        int    `xml:"id,attr"`
-	Title     string `xml:"title,attr"`
-	Type      string `xml:"sever,attr"`
-	Signature string `xml:",chardata"`
-	Regexp    *regexp.Regexp
-}
-
 type FileExtensions map[string]struct{}
 
 func (li FileExtensions) String() string {
@@ -57,223 +43,1168 @@ func (li *FileExtensions) Set(value string) error {
 	return nil
 }
 
+// DatabaseList is the list of database sources. The flag can be repeated
+// and each value can be a comma-separated list. The first value given
+// on the command line replaces the default.
+type DatabaseList struct {
+	Paths []string
+	set   bool
+}
+
+func (dl *DatabaseList) String() string {
+	return strings.Join(dl.Paths, ",")
+}
+
+func (dl *DatabaseList) Set(value string) error {
+	if !dl.set {
+		dl.Paths, dl.set = nil, true
+	}
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			dl.Paths = append(dl.Paths, s)
+		}
+	}
+	return nil
+}
+
 var (
-	DBFILE   = "malware_db.xml"
-	ROOTDIR  = "."
-	MAXPROCS = 1
-	FFILTER  = make(FileExtensions)
-	SKIPSOFT = false
+	DBFILE       = DatabaseList{Paths: []string{"malware_db.xml"}}
+	ROOTDIR      = "."
+	MAXPROCS     = 1
+	FFILTER      = make(FileExtensions)
+	SKIPSOFT     = false
+	SEVERITY     SeverityList
+	MINSEVERITY  = ""
+	SKIPSIG      SignatureIds
+	ONLYSIG      SignatureIds
+	INCEXPIRED   = false
+	SCANSTDIN    = false
+	DIRSUMMARY   = false
+	EXITMAP      = make(ExitMap)
+	JSONSTREAM   = false
+	SHOWCONFIG   = false
+	UPDATEDB     = ""
+	VERBOSE      = false
+	VERYVERBOSE  = false
+	LOGFILE      = ""
+	LOGLEVEL     = ""
+	SUPPRESS     = ""
+	NEWERTHAN    = ""
+	OUTPUT       = ""
+	FAILFAST     = false
+	DECODERCMD   = ""
+	DECODERTTL   = 10 * time.Second
+	HEURISTICS   = 0
+	DETECTENC    = false
+	INCDISABLED  = false
+	WRITABLEBY   = ""
+	INTERACTIVE  = false
+	QUARANTINE   = ""
+	SFTP         = ""
+	SFTPKEY      = ""
+	SFTPINSECURE = false
+	ONLYONMATCH  = false
+	MAXFILES     = 0
+	REPORTUNUSED = false
+	LONGEST      = false
+	ALSOMIME     scanner.MimeList
+	MIMETYPES    scanner.MimeList
+	SAMPLERATE   = 1.0
+	SAMPLESEED   int64
+	EVIDENCE     = ""
+	EVIDENCETAR  = false
+	ENTROPY      = false
+	TESTPATTERN  = ""
+	TESTINPUT    = ""
+	BADRULES     = ""
+	DECODERSUM   = false
+	MAXMATCHES   = 0
+	DBGLOB       = ""
+	DECOMPRESS   = false
+	SORTBY       = ""
+	EMBEDDED     = false
+	EXPLAIN      = ""
+	FORMAT       = FORMAT_TEXT
+	MAXSIZE      = int64(scanner.MAXFILESIZE)
+	OVERLAP      = 0
+	EXCLUDE      ExcludeList
+	SCANARCHIVES = false
+	ALLOWLIST    = ""
+	QUIET        = false
+	PROGRESS     = false
+	WATCH        = false
+	NOPREFILTER  = false
+	CHECKDB      = false
+	TESTSTRING   = ""
+	SHOWCONTEXT  = false
+	CONTEXTBYTES = 200
+	FOLLOWLINKS  = false
+	FIRSTMATCH   = false
+	PRINTHASH    = false
+	FILESFROM    = ""
+	STRICTDB     = false
+	DBTIMEOUT    = scanner.FETCH_TIMEOUT
+	DBCACHE      = ""
+	DBREFRESH    = false
+	DBSHA256     = ""
+	QUARSOFT     = false
+	LASTRUN      = ""
+	STATEFILE    = ""
+	NOSTATEREAD  = false
+
+	dirMatches = NewCounter()
+	reporter   *Reporter
+
+	// Number of matches each decoding step contributed to
+	decoderMatches = NewCounter()
+
+	suppressRules SuppressRules
+	suppressed    int64
+
+	// Known-good files and the number of files skipped because of them
+	allowlist    Allowlist
+	allowedFiles int64
+
+	// Files not modified after this time are skipped by the walker
+	modifiedAfter time.Time
+
+	// Files unchanged since the previous run are skipped if set
+	state          *State
+	unchangedFiles int64
+
+	// Only files writable by this account are scanned if set
+	writableFilter *WritableFilter
+	writableCount  struct{ included, excluded int64 }
+
+	// Number of paths queued by the walker and whether
+	// it stopped early because of the -max-files limit
+	queuedFiles int64
+	scanCapped  int32
+
+	// Number of signatures written to the -bad-rules file
+	badRules int
+
+	// Whether a root could not be walked at all
+	rootFailed int32
+
+	// Number of files reachable through several roots
+	duplicateFiles int64
+
+	// Number of files and directories pruned by -exclude
+	excludedPaths int64
+
+	// Number of files with a reported match
+	matchedFiles int64
+
+	// Counters of the scan summary
+	stats ScanStats
+
+	// Moves the matched files away if set
+	quarantine *Quarantine
+
+	// Number of reported matches and whether the scan
+	// stopped because of the -max-total-matches limit
+	totalMatches int64
+	matchCapped  int32
+
+	// Only a fraction of the files is scanned if set
+	sampler *Sampler
+
+	// Copies of the matched files are collected here if set
+	evidence *Evidence
+
+	// Diagnostics and summaries are written here
+	diag io.Writer = os.Stderr
+
+	// The log messages go here instead of stderr if -log-file is given
+	logFile *os.File
+
+	// The source of the files to scan
+	fsys scanner.FileSystem = scanner.LocalFS{}
+
+	// How the remote databases are downloaded and cached
+	fetchOpts scanner.FetchOptions
+
+	// stopScan cancels the scan: the walker stops producing paths
+	// and workers drain the remaining ones without checking them
+	stopScan context.CancelFunc = func() {}
 )
 
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [directory or file ...]\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(flag.CommandLine.Output(), `
+Exit codes:
+  %d  the scan completed and nothing matched
+  %d  something matched (see -exit-map to use other codes per severity,
+     e.g. info=0 not to fail when only info signatures matched),
+     even if some files could not be scanned
+  %d  nothing matched, or only what -exit-map maps to 0, but some files
+     or directories could not be scanned, or a fatal error: the database,
+     the options or the rootdir are invalid
+  %d  the scan was interrupted by SIGINT or SIGTERM, the results are partial
+`, EXIT_CLEAN, DEFAULT_MATCH_EXIT, EXIT_FATAL, EXIT_INTERRUPTED)
+}
+
+// isFlagSet reports whether the flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func init() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 }
 
 func main() {
-	flag.StringVar(&DBFILE, "database", DBFILE, "manul malware xml database `file` (can be http link)")
-	flag.StringVar(&ROOTDIR, "rootdir", ROOTDIR, "filesystem `directory` to scan recursively")
+	flag.Var(&DBFILE, "database", "comma-separated list of manul malware database `files` in xml or json format (can be http links, may be repeated)")
+	flag.BoolVar(&STRICTDB, "strict-db", STRICTDB, "fail if several databases define the same signature id instead of keeping the first one")
+	flag.DurationVar(&DBTIMEOUT, "db-timeout", DBTIMEOUT, "time limit for downloading a remote database")
+	flag.StringVar(&DBCACHE, "db-cache", DBCACHE, "`directory` for the cached copies of the remote databases (default: the rigel directory under the user cache directory)")
+	flag.StringVar(&DBSHA256, "db-sha256", DBSHA256, "expected SHA-256 of the database as served, a hex `digest` or a link to a .sha256 file")
+	flag.BoolVar(&DBREFRESH, "db-refresh", DBREFRESH, "download the remote databases in full even if the cached copies are up to date")
+	flag.StringVar(&DBGLOB, "database-glob", DBGLOB, "load and merge all database files matching the `pattern`, e.g. '/etc/rigel/rules.d/*.xml' (in addition to -database if it is given explicitly)")
+	flag.StringVar(&ROOTDIR, "rootdir", ROOTDIR, "filesystem `directory` to scan recursively (can be a glob pattern); deprecated, pass the directories and files as arguments instead")
+	flag.BoolVar(&FOLLOWLINKS, "follow-symlinks", FOLLOWLINKS, "descend into the symlinked directories of rootdir (each directory is walked once)")
+	flag.BoolVar(&WATCH, "watch", WATCH, "after the scan of rootdir, keep scanning the files created or modified there until interrupted (Linux only)")
+	flag.StringVar(&FILESFROM, "files-from", FILESFROM, "scan the files listed one per line in `file` (- for stdin) instead of walking rootdir")
 	flag.IntVar(&MAXPROCS, "n", MAXPROCS, "number of files to check concurrently")
 	flag.Var(&FFILTER, "filter", "comma-separated list of file `extensions` to scan (default: all text files)")
-	flag.BoolVar(&SKIPSOFT, "skip-soft", SKIPSOFT, "skip soft signatures")
+	flag.BoolVar(&SKIPSOFT, "skip-soft", SKIPSOFT, "skip soft and info signatures, an alias for -min-severity critical")
+	flag.Var(&SEVERITY, "severity", "comma-separated list of signature severities to use, by `name` (critical, soft, info) or code (default: all)")
+	flag.StringVar(&MINSEVERITY, "min-severity", MINSEVERITY, "use only the signatures of this `severity` and above")
+	flag.Var(&SKIPSIG, "skip-sig", "comma-separated list of signature `ids` and id ranges to skip, e.g. 12,40-45,1337")
+	flag.Var(&ONLYSIG, "only-sig", "comma-separated list of signature `ids` and id ranges to use, skipping all others")
+	flag.BoolVar(&SCANSTDIN, "scan-stdin", SCANSTDIN, "scan the content piped to stdin as a single file instead of walking rootdir")
+	flag.BoolVar(&DIRSUMMARY, "dir-summary", DIRSUMMARY, "print the number of matches per directory when the scan finishes")
+	flag.Var(&EXITMAP, "exit-map", "comma-separated `severity=code` pairs setting the exit code for the worst matched severity (default: 1 for any match)")
+	flag.StringVar(&FORMAT, "format", FORMAT, "output `format`: text, json (one JSON object per line, diagnostics included) or csv (matches only)")
+	flag.BoolVar(&JSONSTREAM, "json-stream", JSONSTREAM, "same as -format json, flushing the output after each record")
+	flag.BoolVar(&INCEXPIRED, "include-expired", INCEXPIRED, "do not skip signatures with an expiration date in the past")
+	flag.StringVar(&UPDATEDB, "update-db", UPDATEDB, "download the database from `url` to the local -database file and exit")
+	flag.BoolVar(&VERBOSE, "v", VERBOSE, "verbose output: include the length of the matched region and the source database in the matches")
+	flag.BoolVar(&VERYVERBOSE, "vv", VERYVERBOSE, "log the debug messages and why each skipped file was skipped, same as -log-level trace")
+	flag.StringVar(&LOGLEVEL, "log-level", LOGLEVEL, "lowest `level` of the log messages shown: trace, debug, info, warning or fatal (default: info, fatal with -quiet)")
+	flag.StringVar(&LOGFILE, "log-file", LOGFILE, "append the log messages to `file` instead of stderr")
+	flag.BoolVar(&PROGRESS, "progress", PROGRESS, "print the number of files scanned so far and the scan rate to stderr (a status line on a terminal, a line every 10s otherwise)")
+	flag.BoolVar(&SHOWCONTEXT, "show-context", SHOWCONTEXT, "print the line and offset of each match with a snippet of the matched content")
+	flag.IntVar(&CONTEXTBYTES, "context-bytes", CONTEXTBYTES, "maximum size of the -show-context snippet in `bytes`, centered on the match")
+	flag.BoolVar(&FIRSTMATCH, "first-match", FIRSTMATCH, "report only the first matching signature of each file, which is faster")
+	flag.BoolVar(&QUIET, "quiet", QUIET, "print only the matches and the fatal errors: no warnings, scan summary or run information")
+	flag.StringVar(&ALLOWLIST, "allowlist", ALLOWLIST, "`file` with SHA-256 digests of known-good files that are not scanned")
+	flag.StringVar(&ALLOWLIST, "whitelist", ALLOWLIST, "an alias for -allowlist")
+	flag.BoolVar(&PRINTHASH, "print-hash", PRINTHASH, "include the SHA-256 of the file in the match output, as used by -allowlist")
+	flag.StringVar(&SUPPRESS, "suppress", SUPPRESS, "`file` with rules suppressing matches of a signature id on matching paths")
+	flag.StringVar(&NEWERTHAN, "newer-than", NEWERTHAN, "scan only files modified after the given `time`, in UTC unless it has a zone (e.g. 2006-01-02 15:04:05 or 2006-01-02T15:04:05+03:00)")
+	flag.StringVar(&LASTRUN, "last-run", LASTRUN, "scan only files modified since the time stored in `file`, then store the start time of this run there")
+	flag.StringVar(&STATEFILE, "state", STATEFILE, "skip the files whose size and mtime have not changed since they were scanned without a match by the previous run with the same database, as recorded in `file`, then record this run there")
+	flag.BoolVar(&NOSTATEREAD, "no-state-read", NOSTATEREAD, "with -state, scan all files but still record this run")
+	flag.StringVar(&OUTPUT, "output", OUTPUT, "write matches to `file` instead of stdout (gzip-compressed if the name ends with .gz)")
+	flag.BoolVar(&FAILFAST, "fail-fast", FAILFAST, "stop the scan at the first match")
+	flag.BoolVar(&DECOMPRESS, "decompress", DECOMPRESS, "also match the base64 payloads wrapped into gzinflate, gzuncompress, gzdecode and bzdecompress calls")
+	flag.BoolVar(&EMBEDDED, "embedded", EMBEDDED, "also match the inline scripts and the decoded data: URIs of each file as separate documents")
+	flag.StringVar(&DECODERCMD, "decoder-cmd", DECODERCMD, "external `command` that gets the file content on stdin and prints the decoded content to be matched too")
+	flag.DurationVar(&DECODERTTL, "decoder-timeout", DECODERTTL, "time limit for a single run of the external decoder")
+	flag.IntVar(&HEURISTICS, "heuristics", HEURISTICS, "sensitivity `level` of the heuristic detection of dynamic function calls: 0 (off), 1 (low), 2 (medium), 3 (high)")
+	flag.BoolVar(&DETECTENC, "detect-encoding", DETECTENC, "convert files to UTF-8 before matching according to their BOM or declared charset")
+	flag.BoolVar(&INCDISABLED, "include-disabled", INCDISABLED, "do not skip signatures disabled in the database")
+	flag.StringVar(&WRITABLEBY, "writable-by", WRITABLEBY, "scan only files writable by the given `user[:group]`, e.g. the web server account")
+	flag.BoolVar(&INTERACTIVE, "interactive", INTERACTIVE, "review the matches one by one after the scan and quarantine, delete or suppress them (requires a terminal)")
+	flag.StringVar(&QUARANTINE, "quarantine", QUARANTINE, "move the matched files into `directory`, keeping their path relative to rootdir; with -interactive, only the files chosen for quarantine are moved there, by default into "+DEFAULT_QUARANTINE)
+	flag.BoolVar(&QUARSOFT, "quarantine-soft", QUARSOFT, "with -quarantine, also move the files that only matched soft and info signatures")
+	flag.StringVar(&QUARANTINE, "quarantine-dir", QUARANTINE, "an alias for -quarantine")
+	flag.StringVar(&SFTP, "sftp", SFTP, "scan a remote `user@host[:port]:path` over SFTP instead of rootdir (the password, if needed, is taken from $"+SFTP_PASSWORD_ENV+")")
+	flag.StringVar(&SFTPKEY, "sftp-key", SFTPKEY, "private key `file` for the SFTP authentication (default: ~/.ssh/id_*)")
+	flag.BoolVar(&SFTPINSECURE, "sftp-insecure", SFTPINSECURE, "do not verify the SFTP host key against ~/.ssh/known_hosts")
+	flag.BoolVar(&ONLYONMATCH, "only-on-match", ONLYONMATCH, "print nothing, including warnings and summaries, if nothing matched (errors before the scan starts are always printed)")
+	flag.IntVar(&MAXFILES, "max-files", MAXFILES, "stop after `N` files have been queued for scanning (0 means no limit)")
+	flag.BoolVar(&REPORTUNUSED, "report-unused", REPORTUNUSED, "list the signatures that never matched when the scan finishes (useful over large corpora only)")
+	flag.BoolVar(&NOPREFILTER, "no-prefilter", NOPREFILTER, "run the regexps of all signatures on every file, even when their required literals are missing (for debugging, much slower)")
+	flag.BoolVar(&LONGEST, "longest", LONGEST, "use leftmost-longest instead of leftmost-first matching, so the reported match spans are the longest possible (slower)")
+	flag.BoolVar(&SCANARCHIVES, "scan-archives", SCANARCHIVES, "scan the entries of zip, tar and tar.gz archives")
+	flag.Var(&EXCLUDE, "exclude", "comma-separated list of glob `patterns` of the files and directories to skip, matched against the base name and the path relative to rootdir (may be repeated)")
+	flag.Var(&MIMETYPES, "mime", "comma-separated list of content `types` to scan (default: text/,/xml), checked without -filter or in addition to it; an entry ending with / matches by prefix, one starting with / by suffix")
+	flag.Var(&ALSOMIME, "also-scan-mime", "comma-separated list of content `types` to scan in addition to the -mime ones (text and xml by default), whenever the content type is checked")
+	flag.Float64Var(&SAMPLERATE, "sample-rate", SAMPLERATE, "scan only a random `fraction` (0..1] of the files and estimate the total number of matches")
+	flag.Int64Var(&SAMPLESEED, "sample-seed", SAMPLESEED, "`seed` making the sampling a deterministic function of the path (0 means random)")
+	flag.StringVar(&EVIDENCE, "evidence", EVIDENCE, "copy the matched files with a manifest into a timestamped bundle in `directory`, leaving the originals in place")
+	flag.BoolVar(&EVIDENCETAR, "evidence-tar", EVIDENCETAR, "create the evidence bundle as a .tar.gz file instead of a directory")
+	flag.BoolVar(&ENTROPY, "entropy", ENTROPY, "compute the Shannon entropy of each matched file and add it to the output")
+	flag.StringVar(&EXPLAIN, "explain", EXPLAIN, "run the detection on a single `file`, print every step and exit")
+	flag.BoolVar(&CHECKDB, "check-db", CHECKDB, "check the databases for broken, duplicate and overly broad signatures and exit (exit code 1 if there are problems)")
+	flag.StringVar(&TESTSTRING, "test-string", TESTSTRING, "with -check-db, list the signatures matching the `string` after normalization")
+	flag.StringVar(&TESTPATTERN, "test-pattern", TESTPATTERN, "match a single signature `regexp` against the -test-input file and exit (exit code 1 if it does not match)")
+	flag.StringVar(&TESTINPUT, "test-input", TESTINPUT, "`file` to match the -test-pattern against")
+	flag.StringVar(&BADRULES, "bad-rules", BADRULES, "write signatures that fail to compile to `file` and continue with the rest")
+	flag.BoolVar(&DECODERSUM, "decoder-summary", DECODERSUM, "print the number of matches each decoding step contributed to when the scan finishes")
+	flag.Int64Var(&MAXSIZE, "max-size", MAXSIZE, "stream the files larger than `bytes` in overlapping windows instead of reading them at once (0 means always stream)")
+	flag.IntVar(&OVERLAP, "overlap", OVERLAP, "overlap of the windows of a streamed file in `bytes`: longer catches longer matches across the block boundary but rescans more (0 derives it from the signatures, up to 64K)")
+	flag.IntVar(&MAXMATCHES, "max-total-matches", MAXMATCHES, "stop the scan after `N` matches across all files (0 means no limit)")
+	flag.StringVar(&SORTBY, "sort", SORTBY, "collect the matches and print them sorted by `order` when the scan finishes: path, or severity (critical first, then by path)")
+	flag.BoolVar(&SHOWCONFIG, "show-config", SHOWCONFIG, "print the effective configuration to stderr before scanning")
+	flag.Usage = usage
 	flag.Parse()
 
+	switch {
+	case JSONSTREAM:
+		FORMAT = FORMAT_JSON
+	case FORMAT != FORMAT_TEXT && FORMAT != FORMAT_JSON && FORMAT != FORMAT_CSV:
+		fatal("invalid -format value:", FORMAT)
+	}
+
+	switch {
+	case len(LOGLEVEL) > 0:
+		level, ok := logLevelNames[strings.ToLower(LOGLEVEL)]
+		if !ok {
+			fatal("invalid -log-level value:", LOGLEVEL)
+		}
+		if VERYVERBOSE {
+			fatal("-vv and -log-level cannot be used together")
+		}
+		logLevel = level
+	case VERYVERBOSE:
+		logLevel = LOG_TRACE
+	case QUIET:
+		logLevel = LOG_FATAL
+	}
+	if len(LOGFILE) > 0 {
+		f, err := os.OpenFile(LOGFILE, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fatal("cannot open log file:", err)
+		}
+		logFile = f
+		setLogOutput(f)
+	} else {
+		setLogOutput(os.Stderr)
+	}
+
 	if MAXPROCS < 1 {
 		MAXPROCS = 1
 	}
+	if DBTIMEOUT <= 0 {
+		fatal("invalid -db-timeout value:", DBTIMEOUT)
+	}
+	fetchOpts = scanner.FetchOptions{
+		Client:       &http.Client{Timeout: DBTIMEOUT},
+		CacheDir:     DBCACHE,
+		RefreshCache: DBREFRESH,
+	}
 
-	normalizers, err := compileNormalizers()
-	if err != nil {
-		log.Fatalln("[fatal] failed to compile normalizers:", err)
+	if SHOWCONTEXT && CONTEXTBYTES < 1 {
+		fatal("invalid -context-bytes value:", CONTEXTBYTES)
+	}
+	if HEURISTICS < 0 || HEURISTICS > 3 {
+		fatal("invalid -heuristics level:", HEURISTICS)
+	}
+	if SKIPSOFT {
+		if len(MINSEVERITY) > 0 {
+			fatal("-skip-soft and -min-severity cannot be used together")
+		}
+		MINSEVERITY = scanner.SEVERITY_CRITICAL.String()
+	}
+	var minSeverity scanner.Severity
+	if len(MINSEVERITY) > 0 {
+		var err error
+		if minSeverity, err = scanner.ParseSeverity(MINSEVERITY); err != nil {
+			fatal("invalid -min-severity value:", MINSEVERITY)
+		}
+	}
+	if WATCH {
+		switch {
+		case SCANSTDIN || len(FILESFROM) > 0 || len(SFTP) > 0:
+			fatal("-watch only works with -rootdir")
+		case len(SORTBY) > 0 || INTERACTIVE:
+			fatal("-watch cannot be used with -sort and -interactive, the scan never finishes")
+		}
+	}
+	if len(STATEFILE) > 0 && SCANSTDIN {
+		fatal("-state and -scan-stdin cannot be used together")
+	}
+	if len(SKIPSIG) > 0 && len(ONLYSIG) > 0 {
+		fatal("-skip-sig and -only-sig cannot be used together")
+	}
+	if len(TESTSTRING) > 0 && !CHECKDB {
+		fatal("-test-string only works with -check-db")
+	}
+	if flag.NArg() > 0 {
+		switch {
+		case isFlagSet("rootdir"):
+			fatal("-rootdir and path arguments cannot be used together")
+		case SCANSTDIN || len(FILESFROM) > 0 || len(SFTP) > 0:
+			fatal("path arguments cannot be used with -scan-stdin, -files-from and -sftp")
+		}
+	}
+	if len(FILESFROM) > 0 {
+		switch {
+		case isFlagSet("rootdir"):
+			fatal("-files-from and -rootdir cannot be used together")
+		case len(SFTP) > 0:
+			fatal("-files-from and -sftp cannot be used together")
+		case SCANSTDIN:
+			fatal("-files-from and -scan-stdin cannot be used together")
+		}
+	}
+	if MAXSIZE < 0 {
+		fatal("invalid -max-size value:", MAXSIZE)
+	}
+	if OVERLAP < 0 || OVERLAP >= scanner.READER_BLOCKSIZE {
+		fatal("invalid -overlap value:", OVERLAP)
+	}
+	if SAMPLERATE <= 0 || SAMPLERATE > 1 {
+		fatal("invalid -sample-rate value:", SAMPLERATE)
+	}
+	if SAMPLERATE < 1 {
+		sampler = NewSampler(SAMPLERATE, SAMPLESEED)
+	}
+	switch SORTBY {
+	case "", SORT_PATH, SORT_SEVERITY:
+	default:
+		fatal("invalid -sort value:", SORTBY)
+	}
+	if INTERACTIVE && (!isTerminal(os.Stdin) || SCANSTDIN) {
+		log.Println("[warning] stdin is not a terminal, interactive mode is disabled")
+		INTERACTIVE = false
+	}
+	if len(QUARANTINE) > 0 && (len(SFTP) > 0 || SCANSTDIN) {
+		fatal("-quarantine works only with local files")
+	}
+	// The interactive mode moves the files the operator chose
+	// into the same quarantine, by the same rules
+	switch {
+	case INTERACTIVE:
+		dir := QUARANTINE
+		if len(dir) == 0 {
+			dir = DEFAULT_QUARANTINE
+		}
+		quarantine = &Quarantine{Dir: dir}
+	case len(QUARANTINE) > 0:
+		quarantine = &Quarantine{Dir: QUARANTINE, Soft: QUARSOFT, Auto: true}
 	}
 
-	db, err := readDatabase(DBFILE)
-	if err != nil {
-		log.Fatalln("[fatal] database error:", err)
+	if SHOWCONFIG {
+		currentConfig().Print(os.Stderr, FORMAT == FORMAT_JSON)
 	}
 
-	cPaths := walk(ROOTDIR)
+	startTime := time.Now()
 
-	// Starting scanner-workers
-	var wg sync.WaitGroup
-	for i := 0; i < MAXPROCS; i++ {
-		wg.Add(1)
-		go worker(db.Signatures, normalizers, cPaths, &wg)
+	switch {
+	case len(NEWERTHAN) > 0 && len(LASTRUN) > 0:
+		fatal("-newer-than and -last-run cannot be used together")
+	case len(NEWERTHAN) > 0:
+		t, err := scanner.ParseTime(NEWERTHAN)
+		if err != nil {
+			fatal("invalid -newer-than value:", err)
+		}
+		modifiedAfter = t
+	case len(LASTRUN) > 0:
+		t, err := readLastRun(LASTRUN)
+		if err != nil {
+			fatal("cannot read last run time:", err)
+		}
+		modifiedAfter = t
 	}
-	wg.Wait()
-}
-
-func worker(sigs []Signature, nr []*regexp.Regexp, cPaths chan string, wg *sync.WaitGroup) {
-	defer wg.Done()
 
-	for p := range cPaths {
-		checkFile(p, sigs, nr)
+	if len(UPDATEDB) > 0 {
+		if len(DBFILE.Paths) != 1 {
+			fatal("-update-db requires a single -database file")
+		}
+		n, err := updateDatabase(UPDATEDB, DBFILE.Paths[0])
+		if err != nil {
+			fatal("database update error:", err)
+		}
+		log.Printf("[info] database updated: %d signatures saved to %s\n", n, DBFILE.Paths[0])
+		return
 	}
-}
 
-func unquoteStr(s []byte) []byte {
-	u, err := strconv.Unquote("'" + string(s) + "'")
+	normalizers, err := scanner.CompileNormalizers()
 	if err != nil {
-		return []byte{}
+		fatal("failed to compile normalizers:", err)
 	}
-	return []byte(u)
-}
 
-func checkFile(path string, signatures []Signature, nr []*regexp.Regexp) {
-	f, err := os.Open(path)
-	if err != nil {
-		log.Printf("[warning] %s: %s\n", err, path)
+	if len(TESTPATTERN) > 0 {
+		if len(TESTINPUT) == 0 {
+			fatal("-test-pattern requires -test-input")
+		}
+		ok, err := testPattern(os.Stdout, TESTPATTERN, TESTINPUT, normalizers)
+		if err != nil {
+			fatal("test pattern error:", err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
 		return
 	}
-	defer f.Close()
 
+	dbPaths := DBFILE.Paths
+	if len(DBGLOB) > 0 {
+		// The default -database is replaced unless it was given explicitly
+		matched, err := scanner.GlobDatabases(DBGLOB)
+		switch {
+		case err != nil && !isFlagSet("database"):
+			fatal("database error:", err)
+		case err != nil:
+			log.Printf("[warning] %s\n", err)
+		case !isFlagSet("database"):
+			dbPaths = nil
+		}
+		dbPaths = append(append([]string{}, dbPaths...), matched...)
+	}
 
-	if len(FFILTER) == 0 {
-		head := make([]byte, 512)
-		if n, err := f.Read(head); err == nil {
-			mimeType := http.DetectContentType(head[:n])
-			switch {
-			case strings.HasPrefix(mimeType, "text/"):
-			case strings.HasSuffix(mimeType, "/xml"):
-			default:
-				return
-			}
+	if CHECKDB {
+		if checkDatabases(os.Stdout, dbPaths, TESTSTRING) > 0 {
+			os.Exit(1)
 		}
-		if _, err := f.Seek(0, os.SEEK_SET); err != nil {
-			log.Printf("[warning] %s: %s\n", err, path)
-			return
+		return
+	}
+
+	// From now on diagnostics are held back until it is clear
+	// whether anything matched, the loading of the database included
+	var held *heldWriter
+	if ONLYONMATCH {
+		held = &heldWriter{w: os.Stderr}
+		diag = held
+		if logFile == nil {
+			setLogOutput(held)
 		}
 	}
 
-	st, err := f.Stat()
-	if err != nil {
-		log.Printf("[warning] %s: %s\n", err, path)
-		return
+	opts := scanner.LoadOptions{
+		FetchOptions:    fetchOpts,
+		IncludeDisabled: INCDISABLED,
+		IncludeExpired:  INCEXPIRED,
+		Longest:         LONGEST,
+		Severities:      SEVERITY,
+		MinSeverity:     minSeverity,
+		SkipIds:         scanner.IdRanges(SKIPSIG),
+		OnlyIds:         scanner.IdRanges(ONLYSIG),
+		Strict:          STRICTDB,
 	}
-	if st.Size() > MAXFILESIZE {
-		log.Printf("[warning] file size more than %dM: %s\n", MAXFILESIZE>>(10*2), path)
-		return
+	if len(DBSHA256) > 0 {
+		if len(dbPaths) != 1 {
+			fatal("-db-sha256 only works with a single database")
+		}
+		sum, err := scanner.FetchChecksum(DBSHA256, fetchOpts)
+		if err != nil {
+			fatal("database error:", err)
+		}
+		opts.Checksum = sum
+	}
+	if len(BADRULES) > 0 {
+		// The signatures that fail to compile are set aside
+		// for the maintainer to fix instead of aborting the scan
+		opts.BadRules = func(bad []scanner.Signature) error {
+			badRules = len(bad)
+			return writeBadRules(BADRULES, bad)
+		}
 	}
 
-	c, err := ioutil.ReadAll(f)
+	db, err := scanner.ReadDatabase(dbPaths, opts)
 	if err != nil {
-		log.Printf("[warning] %s: %s\n", err, path)
-		return
+		fatal("database error:", err)
 	}
-	// Normalize content
-	for _, r := range nr[:2] {
-		c = r.ReplaceAll(c, []byte{})
+	if len(dbPaths) > 1 {
+		log.Printf("[info] loaded %d signatures from %d database files\n", len(db.Signatures), len(dbPaths))
 	}
-	for _, r := range nr[2:] {
-		c = r.ReplaceAllFunc(c, unquoteStr)
+
+	if len(SUPPRESS) > 0 {
+		if suppressRules, err = loadSuppressRules(SUPPRESS); err != nil {
+			fatal("suppress rules error:", err)
+		}
 	}
 
-	for _, s := range signatures {
-		if s.Regexp.Match(c) {
-			fmt.Printf("Matched: %s (signature id = %d): %s\n", s.Title, s.Id, path)
-			return
+	if len(ALLOWLIST) > 0 {
+		if allowlist, err = loadAllowlist(ALLOWLIST); err != nil {
+			fatal("allowlist error:", err)
 		}
 	}
-}
 
-func compileNormalizers() ([]*regexp.Regexp, error) {
-	exprs := []string{
-		`(?si:[\'"]\s*?\.\s*?[\'"])`,
-		`(?si:/\*.*?\*/)`,
-		`(?i:\\x([a-fA-F0-9]{1,2}))`,
-		`\\([0-9]{1,3})`,
+	// The database may replace the default normalizers
+	if normalizers, err = db.CompileNormalizers(); err != nil {
+		fatal("failed to compile normalizers:", err)
 	}
 
-	compiled := make([]*regexp.Regexp, 0, len(exprs))
+	scn := scanner.NewScanner(db, normalizers)
+	scn.Options = scanner.Options{
+		DetectEncoding: DETECTENC,
+		Heuristics:     HEURISTICS,
+		Decompress:     DECOMPRESS,
+		Embedded:       EMBEDDED,
+		DecoderCmd:     DECODERCMD,
+		DecoderTimeout: DECODERTTL,
+		Entropy:        ENTROPY,
+		Hash:           PRINTHASH,
+		FirstMatch:     FIRSTMATCH,
+		MaxSize:        MAXSIZE,
+		Overlap:        OVERLAP,
+		NoPrefilter:    NOPREFILTER,
+		Suppress:       isSuppressed,
 
-	for _, i := range exprs {
-		r, err := regexp.Compile(i)
-		if err != nil {
-			return nil, err
+		Extensions:       FFILTER,
+		ContentTypes:     MIMETYPES,
+		AlsoContentTypes: ALSOMIME,
+	}
+	switch {
+	case SHOWCONTEXT:
+		scn.ContextRadius = CONTEXTBYTES / 2
+		scn.ContextLimit = CONTEXTBYTES
+	case INTERACTIVE:
+		scn.ContextRadius = SNIPPET_RADIUS
+	}
+
+	if len(EXPLAIN) > 0 {
+		if _, err := explainFile(os.Stdout, scn, EXPLAIN); err != nil {
+			fatal("explain error:", err)
+		}
+		if held != nil {
+			held.Release(true)
 		}
-		compiled = append(compiled, r)
+		return
 	}
-	return compiled, nil
-}
 
-func readDatabase(path string) (*Database, error) {
-	db := Database{}
+	if len(STATEFILE) > 0 {
+		if state, err = LoadState(STATEFILE, stateFingerprint(db, &scn.Options, suppressRules), !NOSTATEREAD); err != nil {
+			fatal("cannot read state file:", err)
+		}
+	}
 
-	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
-		resp, err := http.Get(DBFILE)
-		if err != nil {
-			return nil, fmt.Errorf("cannot fetch database file (%s): %s", DBFILE, err)
+	if len(WRITABLEBY) > 0 {
+		if writableFilter, err = newWritableFilter(WRITABLEBY); err != nil {
+			fatal("invalid -writable-by value:", err)
 		}
-		defer resp.Body.Close()
-		if err := xml.NewDecoder(resp.Body).Decode(&db); err != nil {
-			return nil, err
+	}
+
+	if len(EVIDENCE) > 0 {
+		if evidence, err = NewEvidence(EVIDENCE, EVIDENCETAR); err != nil {
+			fatal("cannot create evidence bundle:", err)
 		}
+	}
+
+	var out io.WriteCloser = os.Stdout
+	switch {
+	case len(OUTPUT) > 0:
+		if out, err = createOutput(OUTPUT); err != nil {
+			fatal("output error:", err)
+		}
+	}
+
+	// Output to stdout is flushed per record too,
+	// so that it stays as interactive as a plain Printf.
+	// So is the gzipped output, an interrupted or killed scan
+	// leaves the matches found so far readable then
+	_, gzipped := out.(*gzipFile)
+	reporter = NewReporter(out, FORMAT, JSONSTREAM || len(OUTPUT) == 0 || gzipped)
+	reporter.Verbose = VERBOSE
+	reporter.ShowSource = len(dbPaths) > 1
+	reporter.ShowContext = SHOWCONTEXT
+	reporter.Sort = SORTBY
+
+	// Whether the scan stopped before all files were checked
+	interrupted := false
+
+	if SCANSTDIN {
+		handleSignals("interrupted, stopping the scan")
+		atomic.AddInt64(&stats.Walked, 1)
+		_, reason := scanReader(scn, os.Stdin, STDIN_NAME)
+		stats.Record(reason)
+		interrupted = atomic.LoadInt32(&signalled) != 0
 	} else {
-		f, err := os.Open(path)
-		if err != nil {
-			return nil, err
+		var ctx context.Context
+		ctx, stopScan = context.WithCancel(context.Background())
+
+		var roots []string
+		var cPaths <-chan string
+		if len(FILESFROM) > 0 {
+			in := os.Stdin
+			if FILESFROM != "-" {
+				f, err := os.Open(FILESFROM)
+				if err != nil {
+					fatal("cannot read file list:", err)
+				}
+				defer f.Close()
+				in = f
+			}
+			cPaths = readFileList(ctx, scn, in)
+		} else if len(SFTP) > 0 {
+			remote, root, err := dialSFTP(SFTP)
+			if err != nil {
+				fatal("sftp error:", err)
+			}
+			fsys, roots = remote, []string{root}
+		} else {
+			fsys = scanner.LocalFS{FollowSymlinks: FOLLOWLINKS}
+			if flag.NArg() > 0 {
+				// The arguments that do not exist are reported
+				// by the walk, the others are scanned anyway
+				roots = flag.Args()
+			} else {
+				roots = expandRoots(ROOTDIR)
+				if len(roots) == 0 {
+					fatal("nothing to scan in rootdir", ROOTDIR)
+				}
+				for _, r := range roots {
+					if _, err := os.Stat(r); err != nil {
+						fatal("invalid rootdir:", err)
+					}
+				}
+			}
+			if quarantine != nil {
+				quarantine.Roots = roots
+			}
+		}
+
+		if cPaths == nil {
+			cPaths = walk(ctx, scn, roots)
+		}
+		if WATCH {
+			changed, err := watch(ctx, scn, roots)
+			if err != nil {
+				fatal("watch error:", err)
+			}
+			cPaths = chainPaths(cPaths, changed)
+			handleSignals("stopping the watch")
+			log.Printf("[info] watching %s for changes\n", strings.Join(roots, ", "))
+		}
+
+		if !WATCH {
+			handleSignals("interrupted, finishing the files in progress")
+		}
+
+		var progress *Progress
+		if PROGRESS {
+			progress = StartProgress(os.Stderr, FORMAT == FORMAT_JSON)
+		}
+
+		if len(roots) > 0 {
+			logf(LOG_DEBUG, "scanning %s with %d workers", strings.Join(roots, ", "), MAXPROCS)
+		}
+
+		// Starting scanner-workers
+		var wg sync.WaitGroup
+		for i := 0; i < MAXPROCS; i++ {
+			wg.Add(1)
+			go worker(ctx, scn, cPaths, &wg)
 		}
-		defer f.Close()
+		wg.Wait()
+		// The -max-files limit stops the walk without cancelling it
+		interrupted = ctx.Err() != nil || atomic.LoadInt32(&scanCapped) != 0
 
-		if err := xml.NewDecoder(f).Decode(&db); err != nil {
-			return nil, err
+		if progress != nil {
+			progress.Stop()
+		}
+
+		if c, ok := fsys.(io.Closer); ok {
+			c.Close()
 		}
 	}
 
-	if len(db.Signatures) == 0 {
-		return nil, fmt.Errorf("no signatures loaded, check file format")
+	if err := reporter.Flush(); err != nil {
+		log.Println("[warning] cannot write output:", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Println("[warning] cannot write output:", err)
 	}
 
-	for i, sig := range db.Signatures {
-		r, err := regexp.Compile(sig.Signature)
-		if err != nil {
-			return nil, fmt.Errorf("failed to compile signature %d regexp %q: %v", sig.Id, sig.Signature, err)
+	if evidence != nil {
+		if err := evidence.Close(); err != nil {
+			log.Println("[warning] evidence error:", err)
 		}
-		db.Signatures[i].Regexp = r
+		log.Println("[info] evidence collected in", evidence.Path)
 	}
 
-	if SKIPSOFT {
-		var count int
-		for _, sig := range db.Signatures {
-			if sig.Type == "c" {
-				count++
-			}
+	if badRules > 0 {
+		log.Printf("[info] %d broken signatures quarantined to %s\n", badRules, BADRULES)
+	}
+
+	if n := atomic.LoadInt64(&excludedPaths); n > 0 {
+		log.Printf("[info] excluded %d files and directories (-exclude)\n", n)
+	}
+
+	if n := atomic.LoadInt64(&duplicateFiles); n > 0 {
+		log.Printf("[info] skipped %d files already scanned under another path\n", n)
+	}
+
+	if atomic.LoadInt32(&matchCapped) != 0 {
+		log.Printf("[info] scan capped: stopped after %d matches (-max-total-matches)\n", MAXMATCHES)
+		stats.StopReason = STOP_MAX_MATCHES
+	}
+
+	if atomic.LoadInt32(&signalled) != 0 && !WATCH {
+		log.Println("[info] scan interrupted: the results and the summary are partial")
+		stats.StopReason = STOP_INTERRUPTED
+	}
+
+	if atomic.LoadInt32(&scanCapped) != 0 {
+		log.Printf("[info] scan capped: stopped after %d files (-max-files)\n", MAXFILES)
+		stats.StopReason = STOP_MAX_FILES
+	}
+
+	if FAILFAST && interrupted && len(stats.StopReason) == 0 {
+		stats.StopReason = STOP_FAIL_FAST
+	}
+
+	if writableFilter != nil {
+		log.Printf("[info] writable-by filter: %d files included, %d excluded\n",
+			atomic.LoadInt64(&writableCount.included), atomic.LoadInt64(&writableCount.excluded))
+	}
+
+	if n := atomic.LoadInt64(&suppressed); n > 0 {
+		log.Printf("[info] suppressed %d matches\n", n)
+	}
+
+	if n := atomic.LoadInt64(&allowedFiles); n > 0 {
+		log.Printf("[info] skipped %d allowlisted files\n", n)
+	}
+
+	if n := atomic.LoadInt64(&unchangedFiles); n > 0 {
+		log.Printf("[info] skipped %d files unchanged since the previous run (-state)\n", n)
+	}
+
+	if state != nil {
+		if err := state.Save(!interrupted); err != nil {
+			log.Println("[warning] cannot save state:", err)
 		}
-		critSignatures := make([]Signature, 0, count)
-		for _, sig := range db.Signatures {
-			if sig.Type == "c" {
-				critSignatures = append(critSignatures, sig)
-			}
+	}
+
+	if len(LASTRUN) > 0 {
+		if err := saveLastRun(LASTRUN, startTime, !interrupted); err != nil {
+			log.Println("[warning] cannot save last run time:", err)
+		}
+		if interrupted {
+			log.Println("[info] the scan did not complete, the last run time is kept (-last-run)")
 		}
-		return &Database{critSignatures}, nil
 	}
 
-	return &db, nil
+	if DIRSUMMARY {
+		dirMatches.Print(diag, "Matches by directory")
+	}
+	if DECODERSUM {
+		decoderMatches.Print(diag, "Matches by decoder")
+	}
+
+	if sampler != nil {
+		sampler.PrintEstimate(diag, atomic.LoadInt64(&matchedFiles))
+		stats.SampleRate = sampler.rate
+		estimated := sampler.Estimate(atomic.LoadInt64(&matchedFiles))
+		stats.EstimatedMatched = &estimated
+	}
+
+	if REPORTUNUSED {
+		firedSignatures.PrintUnused(diag, db.Signatures)
+	}
+
+	if !QUIET {
+		stats.Matches = atomic.LoadInt64(&totalMatches)
+		stats.Print(diag, time.Since(startTime), FORMAT == FORMAT_JSON)
+	}
+
+	if INTERACTIVE {
+		reviewQueue.Run(os.Stdin, os.Stderr)
+	}
+
+	if !QUIET {
+		hash := configHash(stateFingerprint(db, &scn.Options, suppressRules), opts, &scn.Options, allowlist)
+		newRunInfo(db, hash).Print(diag, FORMAT == FORMAT_JSON)
+	}
+
+	if held != nil {
+		held.Release(anyMatch())
+	}
+
+	os.Exit(exitCode())
 }
 
-func walk(rootdir string) chan string {
-	cPaths := make(chan string, 10)
+func worker(ctx context.Context, scn *scanner.Scanner, cPaths <-chan string, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for p := range cPaths {
+		if ctx.Err() != nil {
+			stats.Record(SKIP_INTERRUPTED)
+			continue
+		}
+		stats.Record(checkFile(scn, p))
+	}
+}
+
+// checkFile scans a single file and returns the reason code
+// if the file was skipped or could not be read.
+func checkFile(scn *scanner.Scanner, path string) Reason {
+	name := fsys.Name(path)
+
+	f, err := fsys.Open(path)
+	if err != nil {
+		reason := errReason(err, ERR_READ)
+		warning(reason, err, name)
+		return reason
+	}
+
+	ms, reason := scanFile(scn, f, name)
+	if state != nil && reason == "" {
+		if info, err := f.Stat(); err == nil {
+			state.Record(path, info, len(ms) > 0)
+		}
+	}
+	f.Close()
 
-	walkFn := func(path string, info os.FileInfo, err error) error {
+	// The file is moved only when it is closed
+	if quarantine != nil && quarantine.Auto && quarantine.Wants(ms) {
+		dst, err := quarantine.Move(path, ms)
 		if err != nil {
-			log.Println("[fatal] walk error:", err)
-			return nil
+			warning(errReason(err, ERR_QUARANTINE), fmt.Sprintf("cannot quarantine: %s", err), name)
+		} else {
+			log.Printf("[info] quarantined %s to %s\n", name, dst)
 		}
-		if info.IsDir() {
-			return nil
+	}
+
+	return reason
+}
+
+// scanFile checks the content type of the open file and scans it.
+// Returns the reported matches.
+func scanFile(scn *scanner.Scanner, f scanner.File, name string) ([]scanner.Match, Reason) {
+	// An allowlisted archive is not unpacked at all
+	if SCANARCHIVES && isArchive(name) {
+		if reason := checkAllowlist(f, name); reason != "" {
+			return nil, reason
 		}
-		if _, ok := FFILTER[filepath.Ext(path)]; !ok && len(FFILTER) > 0 {
-			return nil
+		return scanArchive(scn, f, name)
+	}
+
+	if reason := checkHead(scn, f, name); reason != "" {
+		return nil, reason
+	}
+
+	// Hashing is the last check, since it reads the whole file
+	if reason := checkAllowlist(f, name); reason != "" {
+		return nil, reason
+	}
+
+	return scanReader(scn, f, name)
+}
+
+// checkAllowlist returns SKIP_ALLOWED if the digest of the file is
+// in the allowlist, or the reason the file could not be read.
+// The file is rewound otherwise.
+func checkAllowlist(f scanner.File, name string) Reason {
+	if allowlist == nil {
+		return ""
+	}
+	ok, err := allowlist.Contains(f)
+	if err == nil {
+		_, err = f.Seek(0, os.SEEK_SET)
+	}
+	if err != nil {
+		reason := errReason(err, ERR_READ)
+		warning(reason, err, name)
+		return reason
+	}
+	if ok {
+		atomic.AddInt64(&allowedFiles, 1)
+		skipped(SKIP_ALLOWED, "content digest is in the allowlist", name)
+		return SKIP_ALLOWED
+	}
+	return ""
+}
+
+// STDIN_NAME is the path the matches of -scan-stdin are reported with.
+const STDIN_NAME = "<stdin>"
+
+// localFile reports whether the matched path names a file on the local
+// filesystem, and not the standard input, an archive entry ("archive::entry")
+// or a file on the SFTP server.
+func localFile(path string) bool {
+	if _, ok := fsys.(scanner.LocalFS); !ok {
+		return false
+	}
+	return path != STDIN_NAME && !strings.Contains(path, ARCHIVE_SEP)
+}
+
+// scanReader matches the content from r and reports the matches.
+// The name is only used to report matches and warnings.
+// Returns the reported matches.
+func scanReader(scn *scanner.Scanner, r io.Reader, name string) ([]scanner.Match, Reason) {
+	ms, err := scn.ScanReader(countingReader{interruptibleReader{r}, &stats.Bytes}, name)
+	var reported []scanner.Match
+	for _, m := range ms {
+		if reportMatch(m) {
+			reported = append(reported, m)
 		}
-		cPaths <- path
-		return nil
+	}
+	if len(reported) > 0 {
+		reporter.Report(reported...)
+		atomic.AddInt64(&matchedFiles, 1)
 	}
 
-	go func() {
-		defer close(cPaths)
-		if err := filepath.Walk(rootdir, walkFn); err != nil {
-			log.Println("[fatal] walk error:", err)
+	switch err.(type) {
+	case nil:
+		return reported, ""
+	case *scanner.DecoderError:
+		warning(ERR_DECODER, err, name)
+		return reported, ""
+	}
+	if err == errInterrupted {
+		warning(SKIP_INTERRUPTED, "scanned partially", name)
+		return reported, SKIP_INTERRUPTED
+	}
+	if err == errArchiveTooLarge {
+		warning(SKIP_TOO_LARGE, err, name)
+		return reported, SKIP_TOO_LARGE
+	}
+	reason := errReason(err, ERR_READ)
+	warning(reason, err, name)
+	return reported, reason
+}
+
+// isSuppressed checks the suppress rules and counts the suppressed matches.
+func isSuppressed(id int, name string) bool {
+	if suppressRules.Match(id, name) {
+		firedSignatures.Add(id)
+		atomic.AddInt64(&suppressed, 1)
+		return true
+	}
+	return false
+}
+
+// reportMatch accounts the match and updates the counters,
+// the caller writes the kept matches of a file together.
+// Returns false if the match was dropped because of -max-total-matches.
+func reportMatch(m scanner.Match) bool {
+	// Concurrent workers may find more matches before the scan
+	// is cancelled, they are dropped beyond the limit
+	n := atomic.AddInt64(&totalMatches, 1)
+	if MAXMATCHES > 0 && n > int64(MAXMATCHES) {
+		atomic.AddInt64(&totalMatches, -1)
+		return false
+	}
+
+	if evidence != nil {
+		if err := evidence.Add(m); err != nil {
+			warning(errReason(err, ERR_READ), fmt.Sprintf("cannot collect evidence: %s", err), m.Path)
 		}
-	}()
+	}
+	firedSignatures.Add(m.Id)
+	if INTERACTIVE {
+		reviewQueue.Add(m)
+	}
+	dirMatches.Add(filepath.Dir(m.Path))
+	if len(m.Decoders) == 0 {
+		decoderMatches.Add("(none)")
+	}
+	for _, d := range m.Decoders {
+		decoderMatches.Add(d)
+	}
+	recordSeverity(m.Type)
+	stats.RecordMatch(m.Type)
+
+	if MAXMATCHES > 0 && n == int64(MAXMATCHES) {
+		atomic.StoreInt32(&matchCapped, 1)
+		stopScan()
+	}
+	if FAILFAST {
+		stopScan()
+	}
+	return true
+}
 
-	return cPaths
+// errMaxFiles stops the walk when the -max-files limit is reached.
+var errMaxFiles = errors.New("file limit reached")
+
+// walk feeds the files found under the roots into the returned channel.
+// If there are several roots, files reachable through more than one
+// of them are queued only once.
+func walk(ctx context.Context, scn *scanner.Scanner, roots []string) <-chan string {
+	var visited map[string]struct{}
+	if len(roots) > 1 {
+		visited = make(map[string]struct{})
+		warnOverlaps(roots)
+	}
+
+	isRoot := make(map[string]bool)
+	for _, r := range roots {
+		isRoot[r] = true
+	}
+
+	return scanner.Walk(ctx, fsys, roots, func(path string, info os.FileInfo, err error) (bool, error) {
+		if _, ok := err.(*scanner.BrokenLinkError); ok {
+			warning(SKIP_BROKEN, err, fsys.Name(path))
+			return false, nil
+		}
+		if err != nil {
+			reason := errReason(err, ERR_WALK)
+			warning(reason, err, fsys.Name(path))
+			stats.Record(reason)
+			if isRoot[path] {
+				atomic.StoreInt32(&rootFailed, 1)
+			}
+			return false, nil
+		}
+		if EXCLUDE.Match(rootOf(roots, path), path, info.IsDir()) {
+			atomic.AddInt64(&excludedPaths, 1)
+			skipped(SKIP_FILTERED, "matches -exclude", fsys.Name(path))
+			return false, nil
+		}
+		if info.IsDir() {
+			return quarantine == nil || !quarantine.IsDir(path), nil
+		}
+		return acceptFile(scn, path, info, visited)
+	})
+}
+
+// acceptFile applies the file filters to a path found by the walk
+// or listed in -files-from and counts it. The visited set, if given,
+// rejects the files that were already accepted under another path.
+func acceptFile(scn *scanner.Scanner, path string, info os.FileInfo, visited map[string]struct{}) (bool, error) {
+	// The file the limit stops at is not part of the scan
+	if MAXFILES > 0 && atomic.LoadInt64(&queuedFiles) >= int64(MAXFILES) {
+		atomic.StoreInt32(&scanCapped, 1)
+		return false, errMaxFiles
+	}
+	atomic.AddInt64(&stats.Walked, 1)
+	// The extension filter applies to the archive entries instead
+	if !scn.AcceptName(path) && !(SCANARCHIVES && isArchive(path)) {
+		stats.Record(SKIP_FILTERED)
+		skipped(SKIP_FILTERED, fmt.Sprintf("extension %q is not in -filter", filepath.Ext(path)), fsys.Name(path))
+		return false, nil
+	}
+	if !info.ModTime().After(modifiedAfter) {
+		stats.Record(SKIP_UNCHANGED)
+		skipped(SKIP_UNCHANGED, "not modified after -newer-than", fsys.Name(path))
+		return false, nil
+	}
+	if writableFilter != nil {
+		if !writableFilter.Allow(info) {
+			atomic.AddInt64(&writableCount.excluded, 1)
+			stats.Record(SKIP_FILTERED)
+			skipped(SKIP_FILTERED, "not writable by -writable-by", fsys.Name(path))
+			return false, nil
+		}
+		atomic.AddInt64(&writableCount.included, 1)
+	}
+	if state != nil && state.Unchanged(path, info) {
+		atomic.AddInt64(&unchangedFiles, 1)
+		stats.Record(SKIP_UNCHANGED)
+		skipped(SKIP_UNCHANGED, "unchanged since the previous run (-state)", fsys.Name(path))
+		return false, nil
+	}
+	if visited != nil {
+		key := resolvePath(path)
+		if _, ok := visited[key]; ok {
+			atomic.AddInt64(&duplicateFiles, 1)
+			stats.Record(SKIP_FILTERED)
+			skipped(SKIP_FILTERED, "already queued under another path", fsys.Name(path))
+			return false, nil
+		}
+		visited[key] = struct{}{}
+	}
+	if sampler != nil && !sampler.Take(path) {
+		stats.Record(SKIP_FILTERED)
+		skipped(SKIP_FILTERED, "not in the -sample-rate sample", fsys.Name(path))
+		return false, nil
+	}
+	atomic.AddInt64(&queuedFiles, 1)
+	return true, nil
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// expandRoots returns the directories matching the rootdir pattern.
+// A pattern without glob metacharacters is returned as is.
+func expandRoots(pattern string) []string {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}
+	}
+
+	roots, err := filepath.Glob(pattern)
+	if err != nil {
+		log.Printf("[warning] invalid rootdir pattern %q: %s\n", pattern, err)
+		return nil
+	}
+	if len(roots) == 0 {
+		log.Printf("[warning] rootdir pattern %q matches nothing\n", pattern)
+	}
+	sort.Strings(roots)
+
+	return roots
+}
+
+// warnOverlaps reports roots nested inside other roots. The files under
+// such roots are still scanned only once thanks to the visited set.
+func warnOverlaps(roots []string) {
+	abs := make([]string, len(roots))
+	for i, r := range roots {
+		abs[i] = resolvePath(r)
+	}
+	for i := range abs {
+		for j := range abs {
+			if i == j {
+				continue
+			}
+			if rel, err := filepath.Rel(abs[j], abs[i]); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				if rel == "." && i < j {
+					continue
+				}
+				log.Printf("[warning] rootdir %s overlaps with %s, duplicate files will be skipped\n", roots[i], roots[j])
+				break
+			}
+		}
+	}
+}
+
+// resolvePath returns the absolute path with symbolic links resolved,
+// falling back to the cleaned absolute path.
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	if r, err := filepath.EvalSymlinks(abs); err == nil {
+		return r
+	}
+	return abs
+}
+
+// rootOf returns the root the path was found under.
+// The longest root wins, since the roots may be nested.
+func rootOf(roots []string, path string) string {
+	var best string
+	for _, r := range roots {
+		r = filepath.Clean(r)
+		if path != r && !strings.HasPrefix(path, r+string(filepath.Separator)) && r != string(filepath.Separator) {
+			continue
+		}
+		if len(r) > len(best) {
+			best = r
+		}
+	}
+	return best
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// Sampler decides which of the encountered files are scanned
+// when only a fraction of them is requested.
+type Sampler struct {
+	rate float64
+	seed int64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+
+	seen  int64
+	taken int64
+}
+
+// NewSampler returns a sampler that takes files with the given probability.
+// A non-zero seed makes the decision a deterministic function of the path,
+// so repeated runs with the same seed sample the same files.
+func NewSampler(rate float64, seed int64) *Sampler {
+	s := Sampler{rate: rate, seed: seed}
+	if seed == 0 {
+		s.rnd = rand.New(rand.NewSource(rand.Int63()))
+	}
+	return &s
+}
+
+// Take reports whether the path is sampled.
+func (s *Sampler) Take(path string) bool {
+	atomic.AddInt64(&s.seen, 1)
+
+	var x float64
+	if s.rnd == nil {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d:%s", s.seed, path)
+		x = float64(h.Sum64()>>11) / (1 << 53)
+	} else {
+		s.mu.Lock()
+		x = s.rnd.Float64()
+		s.mu.Unlock()
+	}
+
+	if x < s.rate {
+		atomic.AddInt64(&s.taken, 1)
+		return true
+	}
+	return false
+}
+
+// PrintEstimate writes the sample size and the number of matching files
+// extrapolated to all encountered files.
+func (s *Sampler) PrintEstimate(w io.Writer, matched int64) {
+	seen, taken := atomic.LoadInt64(&s.seen), atomic.LoadInt64(&s.taken)
+
+	fmt.Fprintf(w, "Sampling: scanned %d of %d files (rate %g, effective %.4f)\n",
+		taken, seen, s.rate, ratio(taken, seen))
+	if taken > 0 {
+		fmt.Fprintf(w, "Sampling: %d matching files in the sample (%.2f%%), about %.0f estimated in total\n",
+			matched, ratio(matched, taken)*100, s.Estimate(matched))
+	}
+}
+
+// Estimate returns the number of matching files expected in all
+// the files seen, from the number of them found in the sample.
+func (s *Sampler) Estimate(matched int64) float64 {
+	seen, taken := atomic.LoadInt64(&s.seen), atomic.LoadInt64(&s.taken)
+	return ratio(matched, taken) * float64(seen)
+}
+
+func ratio(a, b int64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
@@ -0,0 +1,71 @@
+package scanner
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Only the head of a file is searched for a charset declaration.
+const CHARSET_SNIFF_LEN = 1024
+
+var (
+	// <meta charset="...">, Content-Type: text/html; charset=...
+	reDeclaredCharset = regexp.MustCompile(`(?i)charset\s*=\s*["']?([A-Za-z0-9_:.-]+)`)
+	// <?xml version="1.0" encoding="..."?>
+	reXMLEncoding = regexp.MustCompile(`(?i)<\?xml[^>]*\sencoding\s*=\s*["']([A-Za-z0-9_:.-]+)`)
+	// mb_internal_encoding('...')
+	reMBEncoding = regexp.MustCompile(`(?i)mb_internal_encoding\s*\(\s*["']([A-Za-z0-9_:.-]+)`)
+)
+
+// detectEncoding returns the encoding of the content determined by its BOM
+// or by a charset declared in the head of the file. A nil encoding
+// means the content is UTF-8 or its encoding is unknown.
+func detectEncoding(c []byte) encoding.Encoding {
+	switch {
+	case bytes.HasPrefix(c, []byte{0xef, 0xbb, 0xbf}):
+		return nil
+	case bytes.HasPrefix(c, []byte{0xff, 0xfe}):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	case bytes.HasPrefix(c, []byte{0xfe, 0xff}):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	}
+
+	head := c
+	if len(head) > CHARSET_SNIFF_LEN {
+		head = head[:CHARSET_SNIFF_LEN]
+	}
+	for _, r := range []*regexp.Regexp{reXMLEncoding, reDeclaredCharset, reMBEncoding} {
+		m := r.FindSubmatch(head)
+		if m == nil {
+			continue
+		}
+		name := strings.ToLower(string(m[1]))
+		if name == "utf-8" || name == "utf8" {
+			return nil
+		}
+		if enc, err := htmlindex.Get(name); err == nil {
+			return enc
+		}
+	}
+
+	return nil
+}
+
+// transcode converts the content to UTF-8 if its encoding can be detected.
+// The content is returned unchanged if it is already UTF-8 or the
+// conversion fails.
+func transcode(c []byte) []byte {
+	enc := detectEncoding(c)
+	if enc == nil {
+		return bytes.TrimPrefix(c, []byte{0xef, 0xbb, 0xbf})
+	}
+	if u, err := enc.NewDecoder().Bytes(c); err == nil {
+		return u
+	}
+	return c
+}
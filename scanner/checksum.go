@@ -0,0 +1,104 @@
+package scanner
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// MAX_DATABASE_SIZE limits the size of a downloaded database
+// and the decompressed size of a gzipped one.
+const MAX_DATABASE_SIZE = 256 << 20
+
+// MAX_CHECKSUM_SIZE limits the size of a .sha256 file read.
+const MAX_CHECKSUM_SIZE = 64 << 10
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isGzip reports whether the content starts with the gzip magic bytes.
+func isGzip(b []byte) bool {
+	return bytes.HasPrefix(b, gzipMagic)
+}
+
+// gunzip decompresses the database content, failing on corrupt
+// streams and on content larger than MAX_DATABASE_SIZE.
+func gunzip(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("corrupt gzip stream: %s", err)
+	}
+	defer zr.Close()
+
+	out, err := ioutil.ReadAll(io.LimitReader(zr, MAX_DATABASE_SIZE+1))
+	if err != nil {
+		return nil, fmt.Errorf("corrupt gzip stream: %s", err)
+	}
+	if len(out) > MAX_DATABASE_SIZE {
+		return nil, fmt.Errorf("decompressed database exceeds %d bytes", MAX_DATABASE_SIZE)
+	}
+	return out, nil
+}
+
+// FetchChecksum resolves the expected SHA-256 of a database. The ref
+// is either a hex digest or a local file or http(s) link to a .sha256
+// file in the sha256sum format, where the digest is the first field.
+func FetchChecksum(ref string, opts FetchOptions) (string, error) {
+	if d, ok := parseDigest(ref); ok {
+		return d, nil
+	}
+	b, err := readChecksumFile(ref, opts)
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch checksum: %s", err)
+	}
+	if fields := strings.Fields(string(b)); len(fields) > 0 {
+		if d, ok := parseDigest(fields[0]); ok {
+			return d, nil
+		}
+	}
+	return "", fmt.Errorf("%s: no SHA-256 digest found", ref)
+}
+
+// readChecksumFile reads the .sha256 file with a plain GET: it is small,
+// changes with every database and so is neither cached nor conditional.
+func readChecksumFile(ref string, opts FetchOptions) ([]byte, error) {
+	if !IsRemote(ref) {
+		return ioutil.ReadFile(ref)
+	}
+	resp, err := opts.client().Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, MAX_CHECKSUM_SIZE))
+}
+
+func parseDigest(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// verifyChecksum compares the SHA-256 of the content as it was
+// served, before any decompression, with the expected digest.
+func verifyChecksum(b []byte, expected string) error {
+	h := sha256.Sum256(b)
+	if got := hex.EncodeToString(h[:]); got != expected {
+		return fmt.Errorf("checksum mismatch: expected sha256 %s, got %s", expected, got)
+	}
+	return nil
+}
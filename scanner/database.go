@@ -0,0 +1,533 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Database is a set of signatures in the manul format.
+type Database struct {
+	Signatures []Signature `xml:"signature" json:"signatures"`
+
+	// Normalization stages replacing DefaultNormalizers, if any
+	Normalizers []NormalizerDef `xml:"normalizer" json:"normalizers,omitempty"`
+}
+
+// CompileNormalizers returns the normalization stages of the database,
+// or the default ones if it defines none.
+func (db *Database) CompileNormalizers() ([]Normalizer, error) {
+	if len(db.Normalizers) == 0 {
+		return CompileNormalizers()
+	}
+	return compileNormalizers(db.Normalizers)
+}
+
+// Signature describes a single malware pattern.
+type Signature struct {
+	Id        int            `xml:"id,attr" json:"id"`
+	Title     string         `xml:"title,attr" json:"title"`
+	Type      string         `xml:"sever,attr" json:"sever"`
+	Severity  Severity       `xml:"-" json:"-"`
+	Expires   string         `xml:"expires,attr" json:"expires,omitempty"`
+	Mode      string         `xml:"mode,attr" json:"mode,omitempty"`
+	Size      int64          `xml:"size,attr" json:"size,omitempty"`
+	Enabled   *bool          `xml:"enabled,attr" json:"enabled,omitempty"`
+	Signature string         `xml:",chardata" json:"signature"`
+	Regexp    *regexp.Regexp `xml:"-" json:"-"`
+
+	// Optional pattern the file path must match as well as the content
+	Name       string         `xml:"name,attr" json:"name,omitempty"`
+	NameRegexp *regexp.Regexp `xml:"-" json:"-"`
+
+	// The database the signature was loaded from
+	Source string `xml:"-" json:"-"`
+}
+
+// Disabled reports whether the signature is turned off in the database.
+func (s *Signature) Disabled() bool {
+	return s.Enabled != nil && !*s.Enabled
+}
+
+// Signature modes
+const (
+	// The pattern is searched anywhere in the normalized content (default)
+	MODE_SEARCH = ""
+	// The pattern must match the whole normalized content
+	MODE_ANCHORED = "anchored"
+	// The signature body is the SHA-256 of the whole raw file content.
+	// An optional size attribute allows to skip hashing of files
+	// that cannot match.
+	MODE_EQUALS = "equals"
+)
+
+// Compile prepares the signature for matching according to its mode.
+// With longest the search patterns use leftmost-longest matching,
+// so the reported spans are the longest possible.
+func (s *Signature) Compile(longest bool) error {
+	if len(s.Name) > 0 {
+		r, err := regexp.Compile(s.Name)
+		if err != nil {
+			return fmt.Errorf("failed to compile signature %d name regexp %q: %v", s.Id, s.Name, err)
+		}
+		s.NameRegexp = r
+	}
+
+	switch s.Mode {
+	case MODE_SEARCH:
+		r, err := regexp.Compile(s.Signature)
+		if err != nil {
+			return fmt.Errorf("failed to compile signature %d regexp %q: %v", s.Id, s.Signature, err)
+		}
+		s.Regexp = r
+		if longest {
+			// The engine has to explore all alternatives to find the longest
+			// one, so matching can be noticeably slower
+			s.Regexp.Longest()
+		}
+	case MODE_ANCHORED:
+		r, err := regexp.Compile(`\A(?:` + s.Signature + `)\z`)
+		if err != nil {
+			return fmt.Errorf("failed to compile signature %d regexp %q: %v", s.Id, s.Signature, err)
+		}
+		s.Regexp = r
+	case MODE_EQUALS:
+		h := strings.ToLower(strings.TrimSpace(s.Signature))
+		if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("signature %d: invalid sha256 digest %q", s.Id, s.Signature)
+		}
+		s.Signature = h
+	default:
+		return fmt.Errorf("signature %d: unknown mode %q", s.Id, s.Mode)
+	}
+	return nil
+}
+
+// MatchName reports whether the file path satisfies the name condition
+// of the signature. It is checked before the content, since it is cheap
+// and most compound signatures are rejected by the name alone.
+func (s *Signature) MatchName(path string) bool {
+	return s.NameRegexp == nil || s.NameRegexp.MatchString(path)
+}
+
+// MatchRaw reports whether the raw content equals the file
+// described by a MODE_EQUALS signature. The digest of the content
+// is computed once by the sum function and only if the size fits.
+func (s *Signature) MatchRaw(c []byte, sum func() string) bool {
+	if s.Size > 0 && s.Size != int64(len(c)) {
+		return false
+	}
+	return sum() == s.Signature
+}
+
+// Time layouts accepted in the "expires" attribute of a signature
+// and in the command line options.
+var timeLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	"02.01.2006",
+}
+
+// Expired reports whether the signature has an expiration date
+// in the past. A malformed date is reported as an error
+// and the signature is considered active.
+func (s *Signature) Expired(now time.Time) (bool, error) {
+	v := strings.TrimSpace(s.Expires)
+	if len(v) == 0 {
+		return false, nil
+	}
+	t, err := ParseTime(v)
+	if err != nil {
+		return false, fmt.Errorf("malformed expiration date %q", s.Expires)
+	}
+	return now.After(t), nil
+}
+
+// ParseTime parses the time in any of the supported layouts. A time
+// without a zone is in UTC, not in the local zone, so the same value
+// means the same instant on every host. RFC 3339 values carry their zone.
+func ParseTime(v string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format: %q", v)
+}
+
+// Fingerprint returns a digest of the loaded signature set. Two databases
+// with the same fingerprint match exactly the same content.
+func (db *Database) Fingerprint() string {
+	h := sha256.New()
+	for _, sig := range db.Signatures {
+		fmt.Fprintf(h, "%d\x00%s\x00%s\x00%d\x00%s\x00", sig.Id, sig.Type, sig.Mode, sig.Size, sig.Signature)
+		if len(sig.Name) > 0 {
+			fmt.Fprintf(h, "name\x00%s\x00", sig.Name)
+		}
+	}
+	for _, n := range db.Normalizers {
+		fmt.Fprintf(h, "normalizer\x00%s\x00%s\x00%s\x00", n.Action, n.Replace, n.Pattern)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IdRange is an inclusive range of signature ids.
+type IdRange struct {
+	From, To int
+}
+
+func (r IdRange) String() string {
+	if r.From == r.To {
+		return strconv.Itoa(r.From)
+	}
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// IdRanges is a set of signature ids given as ranges.
+type IdRanges []IdRange
+
+// Contains reports whether the id is in any of the ranges.
+func (rs IdRanges) Contains(id int) bool {
+	for _, r := range rs {
+		if id >= r.From && id <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadOptions control which signatures ReadDatabase keeps.
+type LoadOptions struct {
+	// How the remote databases are downloaded and cached
+	FetchOptions
+
+	IncludeDisabled bool
+	IncludeExpired  bool
+	Longest         bool
+
+	// Severities of the signatures to keep, all if empty,
+	// and the lowest severity to keep
+	Severities  []Severity
+	MinSeverity Severity
+
+	// Ids of the signatures to drop, and to keep if not empty.
+	// At most one of them may be set.
+	SkipIds IdRanges
+	OnlyIds IdRanges
+
+	// Fail on a signature id defined in several sources
+	// instead of keeping the first definition
+	Strict bool
+
+	// Expected SHA-256 of the raw content of the database,
+	// only allowed with a single source
+	Checksum string
+
+	// If set, the signatures that fail to compile are passed here
+	// and the rest are loaded instead of failing the whole database
+	BadRules func([]Signature) error
+}
+
+// ReadDatabase loads and merges the signature databases from the given
+// sources. Each source is a local file or an http(s) link in either XML
+// or JSON format. If several sources define the same signature id,
+// the first definition wins, unless opts.Strict is set.
+func ReadDatabase(paths []string, opts LoadOptions) (*Database, error) {
+	db := Database{}
+	seen := make(map[int]string)
+
+	if len(opts.Checksum) > 0 && len(paths) != 1 {
+		return nil, fmt.Errorf("a checksum can only be verified for a single database")
+	}
+
+	for _, path := range paths {
+		part, err := loadDatabase(path, opts.Checksum, opts.FetchOptions)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		log.Printf("[debug] %s: %d signatures, %d normalizers\n", path, len(part.Signatures), len(part.Normalizers))
+		db.Normalizers = append(db.Normalizers, part.Normalizers...)
+		for _, sig := range part.Signatures {
+			if src, ok := seen[sig.Id]; ok {
+				if opts.Strict {
+					return nil, fmt.Errorf("duplicate signature id %d in %s (already defined in %s)", sig.Id, path, src)
+				}
+				log.Printf("[warning] duplicate signature id %d in %s (already defined in %s), skipping\n", sig.Id, path, src)
+				continue
+			}
+			seen[sig.Id] = path
+			sig.Source = path
+			db.Signatures = append(db.Signatures, sig)
+		}
+	}
+
+	return prepareDatabase(&db, opts)
+}
+
+// LoadDatabase decodes a single database in XML or JSON format
+// from r and compiles it, skipping the disabled and expired signatures.
+func LoadDatabase(r io.Reader) (*Database, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	db, err := DecodeDatabase(b)
+	if err != nil {
+		return nil, err
+	}
+	return prepareDatabase(db, LoadOptions{})
+}
+
+// prepareDatabase drops the signatures of db the options do not keep
+// and compiles the rest.
+func prepareDatabase(db *Database, opts LoadOptions) (*Database, error) {
+	if len(db.Signatures) == 0 {
+		return nil, fmt.Errorf("no signatures loaded, check file format")
+	}
+	if _, err := db.CompileNormalizers(); err != nil {
+		return nil, err
+	}
+
+	for i := range db.Signatures {
+		sig := &db.Signatures[i]
+		sever, err := ParseSeverity(sig.Type)
+		if err != nil {
+			log.Printf("[warning] signature %d: %s\n", sig.Id, err)
+		}
+		sig.Severity = sever
+	}
+
+	if len(opts.SkipIds) > 0 && len(opts.OnlyIds) > 0 {
+		return nil, fmt.Errorf("the signature ids to skip and to keep cannot be given together")
+	}
+	if ids, only := opts.SkipIds, len(opts.OnlyIds) > 0; len(ids) > 0 || only {
+		if only {
+			ids = opts.OnlyIds
+		}
+		for _, r := range ids {
+			found := false
+			for _, sig := range db.Signatures {
+				if sig.Id >= r.From && sig.Id <= r.To {
+					found = true
+					break
+				}
+			}
+			if !found {
+				log.Printf("[warning] no signatures with id %s in the database\n", r)
+			}
+		}
+		active := make([]Signature, 0, len(db.Signatures))
+		for _, sig := range db.Signatures {
+			if ids.Contains(sig.Id) == only {
+				active = append(active, sig)
+			}
+		}
+		if n := len(db.Signatures) - len(active); n > 0 {
+			log.Printf("[info] skipped %d signatures by id\n", n)
+		}
+		if len(active) == 0 {
+			return nil, fmt.Errorf("no signatures left after filtering by id")
+		}
+		db.Signatures = active
+	}
+
+	if !opts.IncludeDisabled {
+		active := make([]Signature, 0, len(db.Signatures))
+		for _, sig := range db.Signatures {
+			if !sig.Disabled() {
+				active = append(active, sig)
+			}
+		}
+		if n := len(db.Signatures) - len(active); n > 0 {
+			log.Printf("[info] skipped %d disabled signatures\n", n)
+		}
+		if len(active) == 0 {
+			return nil, fmt.Errorf("all signatures are disabled")
+		}
+		db.Signatures = active
+	}
+
+	if !opts.IncludeExpired {
+		now := time.Now()
+		active := make([]Signature, 0, len(db.Signatures))
+		for _, sig := range db.Signatures {
+			expired, err := sig.Expired(now)
+			if err != nil {
+				log.Printf("[warning] signature %d: %s\n", sig.Id, err)
+			}
+			if !expired {
+				active = append(active, sig)
+			}
+		}
+		if n := len(db.Signatures) - len(active); n > 0 {
+			log.Printf("[info] skipped %d expired signatures\n", n)
+		}
+		if len(active) == 0 {
+			return nil, fmt.Errorf("all signatures are expired")
+		}
+		db.Signatures = active
+	}
+
+	if len(opts.Severities) > 0 || opts.MinSeverity > SEVERITY_UNKNOWN {
+		used := make(map[Severity]bool)
+		for _, sig := range db.Signatures {
+			used[sig.Severity] = true
+		}
+		keep := make(map[Severity]bool)
+		for _, sever := range opts.Severities {
+			if !used[sever] {
+				log.Printf("[warning] no signatures with severity %s in the database\n", sever)
+			}
+			keep[sever] = true
+		}
+		active := make([]Signature, 0, len(db.Signatures))
+		for _, sig := range db.Signatures {
+			if (len(keep) == 0 || keep[sig.Severity]) && sig.Severity >= opts.MinSeverity {
+				active = append(active, sig)
+			}
+		}
+		if n := len(db.Signatures) - len(active); n > 0 {
+			log.Printf("[info] skipped %d signatures of other severities\n", n)
+		}
+		if len(active) == 0 {
+			return nil, fmt.Errorf("no signatures of the selected severities")
+		}
+		db.Signatures = active
+	}
+
+	// Only the signatures kept are compiled, a broken one
+	// that is disabled or expired does not fail the load
+	var bad []Signature
+	compiled := make([]Signature, 0, len(db.Signatures))
+	for _, sig := range db.Signatures {
+		if err := sig.Compile(opts.Longest); err != nil {
+			if opts.BadRules == nil {
+				return nil, err
+			}
+			log.Printf("[warning] %s, quarantining the signature\n", err)
+			bad = append(bad, sig)
+			continue
+		}
+		compiled = append(compiled, sig)
+	}
+	if len(bad) > 0 {
+		if err := opts.BadRules(bad); err != nil {
+			return nil, fmt.Errorf("cannot quarantine broken signatures: %s", err)
+		}
+	}
+	if len(compiled) == 0 {
+		return nil, fmt.Errorf("all signatures failed to compile")
+	}
+	db.Signatures = compiled
+
+	return db, nil
+}
+
+// GlobDatabases returns the database files matching the pattern
+// in lexical order, so the rules.d files are merged predictably.
+func GlobDatabases(pattern string) ([]string, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no database files match %q", pattern)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// loadDatabase fetches a single database source, verifies its checksum
+// if not empty and decodes it.
+func loadDatabase(path, checksum string, opts FetchOptions) (*Database, error) {
+	var db *Database
+	_, err := fetchDatabase(path, opts, func(b []byte) error {
+		if len(checksum) > 0 {
+			if err := verifyChecksum(b, checksum); err != nil {
+				return err
+			}
+		}
+		var err error
+		db, err = DecodeDatabase(b)
+		return err
+	})
+	return db, err
+}
+
+// FetchDatabase returns the raw content of a local file or an http(s) link.
+// The remote databases are cached locally, see fetchRemote, and only
+// the content that decodes as a database is cached.
+func FetchDatabase(path string, opts FetchOptions) ([]byte, error) {
+	return fetchDatabase(path, opts, func(b []byte) error {
+		_, err := DecodeDatabase(b)
+		return err
+	})
+}
+
+// fetchDatabase returns the content of the database that passed
+// validate, which is called last on the content returned.
+func fetchDatabase(path string, opts FetchOptions, validate func([]byte) error) ([]byte, error) {
+	if !IsRemote(path) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return b, validate(b)
+	}
+	return fetchRemote(path, opts, validate)
+}
+
+// IsRemote reports whether the database path is an http(s) link.
+func IsRemote(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// DecodeDatabase detects the format of the database content
+// by its first meaningful character and decodes it.
+// Gzipped content is decompressed first.
+// A JSON database is either an object with the "signatures" list
+// or a bare list of signatures.
+func DecodeDatabase(b []byte) (*Database, error) {
+	db := Database{}
+
+	if isGzip(b) {
+		var err error
+		if b, err = gunzip(b); err != nil {
+			return nil, err
+		}
+	}
+
+	t := bytes.TrimLeft(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")), " \t\r\n")
+	switch {
+	case len(t) == 0:
+		return nil, fmt.Errorf("empty database")
+	case t[0] == '{':
+		if err := json.Unmarshal(t, &db); err != nil {
+			return nil, err
+		}
+	case t[0] == '[':
+		if err := json.Unmarshal(t, &db.Signatures); err != nil {
+			return nil, err
+		}
+	default:
+		if err := xml.Unmarshal(b, &db); err != nil {
+			return nil, err
+		}
+	}
+
+	return &db, nil
+}
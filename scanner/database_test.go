@@ -0,0 +1,361 @@
+package scanner
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDatabaseXML = `<?xml version="1.0"?>
+<database>
+<signature id="1" title="eval-post" sever="c">eval\s*\(\s*\$_POST</signature>
+<signature id="2" title="base64" sever="s">base64_decode</signature>
+</database>
+`
+
+const otherDatabaseXML = `<?xml version="1.0"?>
+<database>
+<signature id="10" title="other" sever="c">other_marker</signature>
+</database>
+`
+
+func signatureIds(db *Database) []int {
+	var ids []int
+	for _, sig := range db.Signatures {
+		ids = append(ids, sig.Id)
+	}
+	return ids
+}
+
+func sameIds(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReadDatabaseRelativePath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "db.xml"), []byte(testDatabaseXML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "other.xml"), []byte(otherDatabaseXML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// Each path is loaded from its own argument
+	for path, want := range map[string][]int{"db.xml": {1, 2}, "other.xml": {10}} {
+		db, err := ReadDatabase([]string{path}, LoadOptions{})
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if got := signatureIds(db); !sameIds(got, want) {
+			t.Errorf("%s: signatures %v, want %v", path, got, want)
+		}
+		for _, sig := range db.Signatures {
+			if sig.Source != path {
+				t.Errorf("%s: signature %d has source %q", path, sig.Id, sig.Source)
+			}
+		}
+	}
+
+	if _, err := ReadDatabase([]string{"missing.xml"}, LoadOptions{}); err == nil {
+		t.Error("missing.xml: no error")
+	}
+}
+
+func TestReadDatabaseRemote(t *testing.T) {
+	opts := LoadOptions{FetchOptions: FetchOptions{CacheDir: t.TempDir()}}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/db.xml":
+			w.Write([]byte(testDatabaseXML))
+		case "/other.xml":
+			w.Write([]byte(otherDatabaseXML))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for path, want := range map[string][]int{"/db.xml": {1, 2}, "/other.xml": {10}} {
+		db, err := ReadDatabase([]string{srv.URL + path}, opts)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if got := signatureIds(db); !sameIds(got, want) {
+			t.Errorf("%s: signatures %v, want %v", path, got, want)
+		}
+	}
+
+	if _, err := ReadDatabase([]string{srv.URL + "/missing.xml"}, opts); err == nil {
+		t.Error("missing.xml: no error")
+	}
+}
+
+func TestReadDatabaseSkipSoft(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.xml")
+	if err := os.WriteFile(path, []byte(testDatabaseXML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts LoadOptions
+		want []int
+	}{
+		{LoadOptions{}, []int{1, 2}},
+		{LoadOptions{MinSeverity: SEVERITY_CRITICAL}, []int{1}},
+		{LoadOptions{Severities: []Severity{SEVERITY_SOFT}}, []int{2}},
+	}
+	for _, tt := range tests {
+		db, err := ReadDatabase([]string{path}, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := signatureIds(db); !sameIds(got, tt.want) {
+			t.Errorf("%+v: signatures %v, want %v", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestSignatureNameAndContent(t *testing.T) {
+	db, err := LoadDatabase(strings.NewReader(`<?xml version="1.0"?>
+<database>
+<signature id="1" title="uploader in images" sever="c" name="/uploads/.*\.php$">move_uploaded_file</signature>
+<signature id="2" title="content only" sever="c">eval\s*\(</signature>
+</database>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nr, _ := db.CompileNormalizers()
+	s := NewScanner(db, nr)
+
+	tests := []struct {
+		path    string
+		content string
+		want    []int
+	}{
+		// Both conditions must hold
+		{"site/uploads/x.php", "<?php move_uploaded_file($a, $b);", []int{1}},
+		// The name alone or the content alone is not enough
+		{"site/uploads/x.php", "<?php echo 1;", nil},
+		{"site/lib/upload.php", "<?php move_uploaded_file($a, $b);", nil},
+		{"site/uploads/x.php.txt", "<?php move_uploaded_file($a, $b);", nil},
+		// The signatures without a name condition match any path
+		{"site/uploads/x.php", "<?php eval($x); move_uploaded_file($a, $b);", []int{1, 2}},
+		{"site/lib/x.php", "<?php eval($x); move_uploaded_file($a, $b);", []int{2}},
+	}
+	for _, tt := range tests {
+		ms, err := s.ScanReader(strings.NewReader(tt.content), tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, m := range ms {
+			got = append(got, m.Id)
+		}
+		if !sameIds(got, tt.want) {
+			t.Errorf("%s %q: matches %v, want %v", tt.path, tt.content, got, tt.want)
+		}
+	}
+
+	// Without a name only the content signatures can match
+	ms := s.ScanBytes([]byte("<?php eval($x); move_uploaded_file($a, $b);"))
+	if len(ms) != 1 || ms[0].Id != 2 {
+		t.Errorf("ScanBytes: got %v, want signature 2 only", ms)
+	}
+}
+
+func TestSignatureBadName(t *testing.T) {
+	sig := Signature{Id: 1, Type: "c", Name: "(", Signature: "x"}
+	if err := sig.Compile(false); err == nil {
+		t.Error("invalid name regexp compiled")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-03-01 12:30:00", time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{"2024-03-01T12:30:00", time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{"01.03.2024", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		// The zone of the value is kept
+		{"2024-03-01T12:30:00+03:00", time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)},
+		{"2024-03-01T12:30:00Z", time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.value)
+		if err != nil {
+			t.Errorf("%s: %s", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.value, got, tt.want)
+		}
+	}
+	if _, err := ParseTime("yesterday"); err == nil {
+		t.Error("yesterday: no error")
+	}
+}
+
+func TestFetchRemoteCachesValidOnly(t *testing.T) {
+	opts := FetchOptions{CacheDir: t.TempDir()}
+
+	body := testDatabaseXML
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", fmt.Sprintf("%q", fmt.Sprint(len(body))))
+		if r.Header.Get("If-None-Match") == w.Header().Get("ETag") {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	load := func() (*Database, error) {
+		return ReadDatabase([]string{srv.URL + "/db.xml"}, LoadOptions{FetchOptions: opts})
+	}
+
+	if _, err := load(); err != nil {
+		t.Fatal(err)
+	}
+	cache := openCache(srv.URL+"/db.xml", opts.CacheDir)
+	if string(cache.load()) != testDatabaseXML {
+		t.Fatal("the valid database is not cached")
+	}
+
+	// Not modified, the cached copy is used
+	db, err := load()
+	if err != nil || len(db.Signatures) != 2 {
+		t.Fatalf("not modified: %v, %v", db, err)
+	}
+
+	// A broken download does not replace the cached copy,
+	// which is used instead
+	body = "<database><signature"
+	db, err = load()
+	if err != nil || len(db.Signatures) != 2 {
+		t.Errorf("broken download: %v, %v", db, err)
+	}
+	if string(openCache(srv.URL+"/db.xml", opts.CacheDir).load()) != testDatabaseXML {
+		t.Error("the broken download replaced the cached copy")
+	}
+
+	// A checksum mismatch does not replace it either
+	body = otherDatabaseXML
+	_, err = ReadDatabase([]string{srv.URL + "/db.xml"}, LoadOptions{FetchOptions: opts, Checksum: strings.Repeat("0", 64)})
+	if err == nil {
+		t.Error("checksum mismatch: no error")
+	}
+	if string(openCache(srv.URL+"/db.xml", opts.CacheDir).load()) != testDatabaseXML {
+		t.Error("the content with a wrong checksum replaced the cached copy")
+	}
+}
+
+func TestFetchChecksum(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	opts := FetchOptions{CacheDir: t.TempDir()}
+
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.Header().Set("ETag", `"1"`)
+		switch r.URL.Path {
+		case "/db.xml.sha256":
+			fmt.Fprintf(w, "%s  db.xml\n", strings.ToUpper(digest))
+		case "/bad.sha256":
+			w.Write([]byte("not a digest\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		got, err := FetchChecksum(srv.URL+"/db.xml.sha256", opts)
+		if err != nil || got != digest {
+			t.Fatalf("got %q, %v, want %s", got, err, digest)
+		}
+	}
+	for _, r := range requests {
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			t.Errorf("conditional request for the checksum: %v", r.Header)
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(opts.CacheDir, "*")); len(files) != 0 {
+		t.Errorf("the checksum is cached: %v", files)
+	}
+
+	if _, err := FetchChecksum(srv.URL+"/bad.sha256", opts); err == nil {
+		t.Error("no digest: no error")
+	}
+	if _, err := FetchChecksum(srv.URL+"/missing.sha256", opts); err == nil {
+		t.Error("missing file: no error")
+	}
+
+	// A bare digest and a local file
+	if got, _ := FetchChecksum(" "+digest+"\n", opts); got != digest {
+		t.Errorf("bare digest: got %q", got)
+	}
+	path := filepath.Join(t.TempDir(), "db.xml.sha256")
+	os.WriteFile(path, []byte(digest+"  db.xml\n"), 0644)
+	if got, err := FetchChecksum(path, opts); got != digest {
+		t.Errorf("local file: got %q, %v", got, err)
+	}
+}
+
+func TestReadDatabaseDeadRulesNotCompiled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.xml")
+	if err := os.WriteFile(path, []byte(`<?xml version="1.0"?>
+<database>
+<signature id="1" title="eval-post" sever="c">eval\s*\(\s*\$_POST</signature>
+<signature id="2" title="broken, disabled" sever="c" enabled="false">(</signature>
+<signature id="3" title="broken, expired" sever="c" expires="2020-01-01">[</signature>
+</database>
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := ReadDatabase([]string{path}, LoadOptions{})
+	if err != nil {
+		t.Fatalf("the dead rules failed the load: %s", err)
+	}
+	if got := signatureIds(db); !sameIds(got, []int{1}) {
+		t.Errorf("signatures %v, want [1]", got)
+	}
+	if db.Signatures[0].Regexp == nil {
+		t.Error("the active signature is not compiled")
+	}
+
+	// Once included they are compiled and fail as before
+	if _, err := ReadDatabase([]string{path}, LoadOptions{IncludeDisabled: true}); err == nil {
+		t.Error("included disabled rule: no error")
+	}
+	if _, err := ReadDatabase([]string{path}, LoadOptions{IncludeExpired: true}); err == nil {
+		t.Error("included expired rule: no error")
+	}
+}
@@ -0,0 +1,213 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FETCH_TIMEOUT is the time limit of a remote database download
+// if FetchOptions.Client is not set.
+const FETCH_TIMEOUT = 60 * time.Second
+
+// Unlike the default client it gives up on an endpoint
+// that stops responding.
+var defaultClient = &http.Client{Timeout: FETCH_TIMEOUT}
+
+// FetchOptions control the downloads of the remote databases.
+type FetchOptions struct {
+	// The client the databases are fetched with, a client
+	// with FETCH_TIMEOUT if nil
+	Client *http.Client
+
+	// Where the copies of the remote databases are kept. If empty,
+	// the rigel directory under the user cache directory is used
+	CacheDir string
+
+	// Ignore the cached copies and download the databases in full.
+	// The copies are still updated and used as a fallback
+	// if the server cannot be reached
+	RefreshCache bool
+}
+
+func (o FetchOptions) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return defaultClient
+}
+
+// cacheEntry describes a cached copy of a remote database.
+// The validators are sent back to the server on the next fetch.
+type cacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	dir string
+	key string
+}
+
+// openCache returns the cache entry of the url. The copies are
+// kept in dir or under the user cache directory ($XDG_CACHE_HOME/rigel
+// on Linux) and keyed by the digest of the url. The zero entry is returned
+// if there is no cache directory, so the fetch works without it.
+func openCache(url, dir string) *cacheEntry {
+	e := &cacheEntry{URL: url, dir: dir}
+	if len(e.dir) == 0 {
+		d, err := os.UserCacheDir()
+		if err != nil {
+			return e
+		}
+		e.dir = filepath.Join(d, "rigel")
+	}
+	h := sha256.Sum256([]byte(url))
+	e.key = hex.EncodeToString(h[:])
+
+	if b, err := ioutil.ReadFile(e.path(".json")); err == nil {
+		json.Unmarshal(b, e)
+	}
+	return e
+}
+
+func (e *cacheEntry) path(ext string) string {
+	return filepath.Join(e.dir, e.key+ext)
+}
+
+// load returns the cached content or nil if there is none.
+func (e *cacheEntry) load() []byte {
+	if len(e.dir) == 0 {
+		return nil
+	}
+	b, err := ioutil.ReadFile(e.path(".db"))
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// store saves the content with the validators of the response headers.
+func (e *cacheEntry) store(b []byte, header http.Header) error {
+	if len(e.dir) == 0 {
+		return nil
+	}
+	e.ETag = header.Get("ETag")
+	e.LastModified = header.Get("Last-Modified")
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(e.dir, 0755); err != nil {
+		return err
+	}
+	// The content goes first, so the validators never describe
+	// a copy that was not written
+	if err := writeFileAtomic(e.path(".db"), b); err != nil {
+		return err
+	}
+	return writeFileAtomic(e.path(".json"), meta)
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".rigel-cache-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// fetchRemote downloads the database from url. A cached copy is reused
+// if the server reports it as not modified, and also if the server
+// cannot be reached at all or serves content that fails validation.
+// The downloaded content is only cached once validate accepts it,
+// so a broken download never replaces a working copy.
+func fetchRemote(url string, opts FetchOptions, validate func([]byte) error) ([]byte, error) {
+	cache := openCache(url, opts.CacheDir)
+	cached := cache.load()
+
+	b, header, err := fetchConditional(opts.client(), url, cache, cached != nil && !opts.RefreshCache)
+	if err == nil && b != nil {
+		err = validate(b)
+	}
+	if err != nil {
+		if cached != nil && validate(cached) == nil {
+			log.Printf("[warning] %s, using the cached copy of %s\n", err, url)
+			return cached, nil
+		}
+		return nil, err
+	}
+	if b == nil {
+		return cached, validate(cached)
+	}
+
+	if err := cache.store(b, header); err != nil {
+		log.Printf("[warning] cannot cache database %s: %s\n", url, err)
+	}
+	return b, nil
+}
+
+// fetchConditional sends the request with the validators of the cached
+// copy, if any. It returns nil content if the copy is still valid.
+func fetchConditional(client *http.Client, url string, cache *cacheEntry, conditional bool) ([]byte, http.Header, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Asking for gzip explicitly keeps the transport from decompressing
+	// the body, so the content is verified and cached as served
+	req.Header.Set("Accept-Encoding", "gzip")
+	if conditional {
+		if len(cache.ETag) > 0 {
+			req.Header.Set("If-None-Match", cache.ETag)
+		}
+		if len(cache.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", cache.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot fetch database file: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && conditional:
+		return nil, nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, nil, fmt.Errorf("cannot fetch database file: %s", resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_DATABASE_SIZE+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot fetch database file: %s", err)
+	}
+	if len(b) > MAX_DATABASE_SIZE {
+		return nil, nil, fmt.Errorf("database file exceeds %d bytes", MAX_DATABASE_SIZE)
+	}
+	if resp.ContentLength >= 0 && int64(len(b)) != resp.ContentLength {
+		return nil, nil, fmt.Errorf("truncated database file: got %d bytes, expected %d", len(b), resp.ContentLength)
+	}
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") && !isGzip(b) {
+		return nil, nil, fmt.Errorf("corrupt gzip stream: the content is not gzipped")
+	}
+	return b, resp.Header, nil
+}
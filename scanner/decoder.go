@@ -0,0 +1,60 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var errDecoderOutput = errors.New("decoder output limit exceeded")
+
+// limitedBuffer is a buffer that refuses to grow beyond max bytes.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, errDecoderOutput
+	}
+	return b.Buffer.Write(p)
+}
+
+// runDecoder pipes the content through the external decoder command
+// and returns its output. The command line is split on spaces without
+// any shell interpretation. The run is bounded by the timeout
+// and the output by MAXFILESIZE.
+func runDecoder(cmdline string, c []byte, timeout time.Duration) ([]byte, error) {
+	args := strings.Fields(cmdline)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty decoder command")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stdout := limitedBuffer{max: MAXFILESIZE}
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(c)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		switch {
+		case ctx.Err() == context.DeadlineExceeded:
+			return nil, fmt.Errorf("decoder timed out after %s", timeout)
+		case stderr.Len() > 0:
+			return nil, fmt.Errorf("decoder failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("decoder failed: %s", err)
+	}
+
+	return stdout.Bytes(), nil
+}
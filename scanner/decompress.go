@@ -0,0 +1,95 @@
+package scanner
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Maximum number of nested compression layers unwrapped in a file.
+const MAX_DECOMPRESS_DEPTH = 4
+
+// gzinflate(base64_decode('...')) and the like, the blob may be split
+// by whitespace. The content is expected to be normalized, so the
+// concatenated string literals are already joined.
+var reCompressed = regexp.MustCompile(`(?i)\b(gzinflate|gzuncompress|gzdecode|bzdecompress)\s*\(\s*base64_decode\s*\(\s*['"]([A-Za-z0-9+/=\s]+)['"]\s*\)`)
+
+// decompressors map the PHP functions to the matching readers.
+var decompressors = map[string]func(io.Reader) (io.Reader, error){
+	"gzinflate": func(r io.Reader) (io.Reader, error) {
+		return flate.NewReader(r), nil
+	},
+	"gzuncompress": func(r io.Reader) (io.Reader, error) {
+		return zlib.NewReader(r)
+	},
+	"gzdecode": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	"bzdecompress": func(r io.Reader) (io.Reader, error) {
+		return bzip2.NewReader(r), nil
+	},
+}
+
+// decompressPayloads unwraps the base64 blobs passed to the PHP
+// decompression functions and returns the decoded payloads joined
+// by newlines along with the names of the functions involved.
+// Payloads are unwrapped again up to MAX_DECOMPRESS_DEPTH layers.
+// The total output is bounded by MAXFILESIZE.
+func decompressPayloads(c []byte) ([]byte, []string) {
+	var out []byte
+	var funcs []string
+	seen := make(map[string]bool)
+
+	for depth := 0; depth < MAX_DECOMPRESS_DEPTH && len(c) > 0; depth++ {
+		var layer []byte
+		for _, m := range reCompressed.FindAllSubmatch(c, -1) {
+			fn := strings.ToLower(string(m[1]))
+			p, err := decompress(fn, m[2], MAXFILESIZE-len(out)-len(layer))
+			if err != nil || len(p) == 0 {
+				continue
+			}
+			layer = append(append(layer, p...), '\n')
+			if !seen[fn] {
+				seen[fn] = true
+				funcs = append(funcs, fn)
+			}
+		}
+		out = append(out, layer...)
+		c = layer
+	}
+
+	return out, funcs
+}
+
+// decompress decodes the base64 blob and decompresses it with the reader
+// matching the PHP function. At most max bytes of the output are returned.
+func decompress(fn string, blob []byte, max int) ([]byte, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+
+	blob = bytes.Join(bytes.Fields(blob), nil)
+	raw := make([]byte, base64.StdEncoding.DecodedLen(len(blob)))
+	n, err := base64.StdEncoding.Decode(raw, blob)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := decompressors[fn](bytes.NewReader(raw[:n]))
+	if err != nil {
+		return nil, err
+	}
+	// A truncated stream still yields useful content
+	p, err := ioutil.ReadAll(io.LimitReader(r, int64(max)))
+	if len(p) > 0 {
+		return p, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,135 @@
+package scanner
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPayload = `<?php system($_GET["c"]); ?>`
+
+// The payload compressed by bzip2 -9, there is no bzip2 writer in Go.
+const testPayloadBzip2 = "QlpoOTFBWSZTWfPO7vIAAAQfgFRgAA2CgAQKikJMICAAMUAGI000aFABpkZNGlFPVlMBIC2hoaQ8i9FiZ3xdyRThQkPPO7vI"
+
+// compressed returns the payload as PHP would produce it
+// with the function fn, base64 encoded.
+func compressed(t *testing.T, fn string, payload string) string {
+	t.Helper()
+	if fn == "bzdecompress" {
+		if payload != testPayload {
+			t.Fatal("only the test payload can be bzip2 compressed")
+		}
+		return testPayloadBzip2
+	}
+
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch fn {
+	case "gzinflate":
+		w, _ = flate.NewWriter(&b, flate.BestCompression)
+	case "gzuncompress":
+		w = zlib.NewWriter(&b)
+	case "gzdecode":
+		w = gzip.NewWriter(&b)
+	default:
+		t.Fatalf("unknown function %s", fn)
+	}
+	io.WriteString(w, payload)
+	w.Close()
+	return base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+func TestDecompressPayloads(t *testing.T) {
+	for _, fn := range []string{"gzinflate", "gzuncompress", "gzdecode", "bzdecompress"} {
+		blob := compressed(t, fn, testPayload)
+
+		// The way the droppers usually write it, and split by whitespace
+		for _, c := range []string{
+			fmt.Sprintf(`<?php eval(%s(base64_decode('%s'))); ?>`, fn, blob),
+			fmt.Sprintf("<?php eval(%s( base64_decode(\"%s\n%s\") )); ?>", strings.ToUpper(fn), blob[:20], blob[20:]),
+		} {
+			out, funcs := decompressPayloads([]byte(c))
+			if got := strings.TrimSpace(string(out)); got != testPayload {
+				t.Errorf("%s: payload %q, want %q", fn, got, testPayload)
+			}
+			if !reflect.DeepEqual(funcs, []string{fn}) {
+				t.Errorf("%s: functions %v", fn, funcs)
+			}
+		}
+	}
+}
+
+func TestDecompressPayloadsNested(t *testing.T) {
+	inner := fmt.Sprintf(`eval(gzuncompress(base64_decode('%s')));`, compressed(t, "gzuncompress", testPayload))
+	c := fmt.Sprintf(`<?php eval(gzinflate(base64_decode('%s')));`, compressed(t, "gzinflate", inner))
+
+	out, funcs := decompressPayloads([]byte(c))
+	if !bytes.Contains(out, []byte(testPayload)) {
+		t.Errorf("inner payload not unwrapped: %q", out)
+	}
+	if !reflect.DeepEqual(funcs, []string{"gzinflate", "gzuncompress"}) {
+		t.Errorf("functions %v", funcs)
+	}
+}
+
+func TestDecompressPayloadsBroken(t *testing.T) {
+	gz := compressed(t, "gzuncompress", testPayload)
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"invalid base64", `gzinflate(base64_decode('@@@@'))`, ""},
+		{"not compressed", `gzinflate(base64_decode('` + base64.StdEncoding.EncodeToString([]byte("plain text")) + `'))`, ""},
+		{"wrong function", `gzdecode(base64_decode('` + gz + `'))`, ""},
+		{"no wrapper", `base64_decode('` + gz + `')`, ""},
+	}
+	for _, tt := range tests {
+		if out, _ := decompressPayloads([]byte(tt.content)); string(out) != tt.want {
+			t.Errorf("%s: payload %q, want %q", tt.name, out, tt.want)
+		}
+	}
+}
+
+func TestDecompressTruncated(t *testing.T) {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	io.WriteString(w, strings.Repeat(testPayload, 100))
+	w.Flush()
+	blob := base64.StdEncoding.EncodeToString(b.Bytes())
+
+	// The stream is not closed, so the checksum is missing
+	p, err := decompress("gzuncompress", []byte(blob), MAXFILESIZE)
+	if err != nil || !bytes.HasPrefix(p, []byte(testPayload)) {
+		t.Errorf("truncated stream: %q, %v", p, err)
+	}
+
+	// The output is bounded
+	if p, _ := decompress("gzuncompress", []byte(blob), 10); len(p) != 10 {
+		t.Errorf("bounded output: %d bytes, want 10", len(p))
+	}
+}
+
+func TestScannerDecompress(t *testing.T) {
+	db := compileTestDatabase(t, `system\s*\(\s*\$_GET`)
+	nr, _ := CompileNormalizers()
+	c := []byte(fmt.Sprintf(`<?php eval(gzuncompress(base64_decode('%s')));`, compressed(t, "gzuncompress", testPayload)))
+
+	s := NewScanner(db, nr)
+	if ms := s.ScanBytes(c); len(ms) != 0 {
+		t.Errorf("decompression disabled: got %v", ms)
+	}
+
+	s.Decompress = true
+	ms := s.ScanBytes(c)
+	if len(ms) != 1 || len(ms[0].Decoders) == 0 || ms[0].Decoders[0] != "gzuncompress" {
+		t.Errorf("decompression enabled: got %+v", ms)
+	}
+}
@@ -0,0 +1,60 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/url"
+	"regexp"
+)
+
+var (
+	// <script ...>...</script>, the content is the first group
+	reInlineScript = regexp.MustCompile(`(?is)<script\b[^>]*>(.*?)</script\s*>`)
+	// data:[<media type>][;base64],<data>, the payload is the last group
+	reDataURI = regexp.MustCompile(`(?i)\bdata:[\w.+-]*/?[\w.+-]*((?:;[\w.+-]+=[\w.+-]+)*)(;base64)?,([A-Za-z0-9+/=%._~!$&*:@-]+)`)
+)
+
+// embeddedDoc is a sub-document extracted from a file.
+type embeddedDoc struct {
+	kind    string // "script" or "data-uri"
+	offset  int    // offset of the sub-document in the file
+	content []byte
+}
+
+// extractEmbedded returns the contents of the inline scripts and
+// the decoded payloads of the data: URIs found in the content.
+// Undecodable data: URIs are skipped.
+func extractEmbedded(c []byte) []embeddedDoc {
+	var docs []embeddedDoc
+
+	for _, loc := range reInlineScript.FindAllSubmatchIndex(c, -1) {
+		if body := c[loc[2]:loc[3]]; len(bytes.TrimSpace(body)) > 0 {
+			docs = append(docs, embeddedDoc{"script", loc[2], body})
+		}
+	}
+
+	for _, loc := range reDataURI.FindAllSubmatchIndex(c, -1) {
+		data := c[loc[6]:loc[7]]
+		var p []byte
+		if loc[4] >= 0 {
+			p = make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+			// The payload decoded before a malformed tail is still scanned
+			n, err := base64.StdEncoding.Decode(p, data)
+			if err != nil && n == 0 {
+				continue
+			}
+			p = p[:n]
+		} else {
+			s, err := url.PathUnescape(string(data))
+			if err != nil {
+				continue
+			}
+			p = []byte(s)
+		}
+		if len(p) > 0 {
+			docs = append(docs, embeddedDoc{"data-uri", loc[0], p})
+		}
+	}
+
+	return docs
+}
@@ -0,0 +1,48 @@
+package scanner
+
+import (
+	"math"
+)
+
+// entropy returns the Shannon entropy of the content in bits per byte,
+// rounded to two decimal places. Plain source code usually stays below 5.5,
+// while packed, encrypted or heavily encoded content approaches 8.
+func entropy(c []byte) float64 {
+	var f byteFreq
+	f.Write(c)
+	return f.Entropy()
+}
+
+// byteFreq counts the byte values of the content written to it,
+// so the entropy of a streamed file is computed in a single pass.
+type byteFreq struct {
+	freq [256]int64
+	n    int64
+}
+
+func (f *byteFreq) Write(c []byte) (int, error) {
+	for _, b := range c {
+		f.freq[b]++
+	}
+	f.n += int64(len(c))
+	return len(c), nil
+}
+
+// Entropy returns the entropy of the content written so far.
+func (f *byteFreq) Entropy() float64 {
+	if f.n == 0 {
+		return 0
+	}
+
+	var h float64
+	n := float64(f.n)
+	for _, v := range f.freq {
+		if v == 0 {
+			continue
+		}
+		p := float64(v) / n
+		h -= p * math.Log2(p)
+	}
+
+	return math.Round(h*100) / 100
+}
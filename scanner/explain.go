@@ -0,0 +1,130 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Explain runs all detection stages on the content and writes
+// the transformations of the content and the result of each signature
+// at every stage, including the ones already matched at an earlier stage.
+// Returns whether any signature matched.
+func (s *Scanner) Explain(w io.Writer, name string, c []byte) bool {
+	flagged := false
+	verdict := func(sig *Signature, loc []int) {
+		switch {
+		case loc == nil:
+			fmt.Fprintf(w, "    signature %d (%s): no match\n", sig.Id, sig.Title)
+		case s.suppressed(sig, name):
+			fmt.Fprintf(w, "    signature %d (%s): match at %d-%d, suppressed\n", sig.Id, sig.Title, loc[0], loc[1])
+		default:
+			fmt.Fprintf(w, "    signature %d (%s): MATCH at %d-%d\n", sig.Id, sig.Title, loc[0], loc[1])
+			flagged = true
+		}
+	}
+	matchAll := func(stage string, n []byte) {
+		fmt.Fprintf(w, "  %s (%d bytes):\n", stage, len(n))
+		for i := range s.db.Signatures {
+			sig := &s.db.Signatures[i]
+			switch {
+			case sig.Regexp == nil:
+				continue
+			case !sig.MatchName(name):
+				fmt.Fprintf(w, "    signature %d (%s): name condition %q does not match\n", sig.Id, sig.Title, sig.Name)
+			default:
+				verdict(sig, sig.Regexp.FindIndex(n))
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "Pipeline (entropy %.2f):\n", entropy(c))
+
+	h := sha256.Sum256(c)
+	digest := hex.EncodeToString(h[:])
+	fmt.Fprintf(w, "  raw content, sha256 %s:\n", digest)
+	for i := range s.db.Signatures {
+		sig := &s.db.Signatures[i]
+		if sig.Mode != MODE_EQUALS {
+			continue
+		}
+		switch {
+		case !sig.MatchName(name):
+			fmt.Fprintf(w, "    signature %d (%s): name condition %q does not match\n", sig.Id, sig.Title, sig.Name)
+		case sig.Size > 0 && sig.Size != int64(len(c)):
+			fmt.Fprintf(w, "    signature %d (%s): size %d differs\n", sig.Id, sig.Title, sig.Size)
+		case sig.Signature == digest:
+			verdict(sig, []int{0, len(c)})
+		default:
+			verdict(sig, nil)
+		}
+	}
+
+	if s.DetectEncoding {
+		if enc := detectEncoding(c); enc != nil {
+			fmt.Fprintf(w, "  charset: converted from %s\n", enc)
+		} else {
+			fmt.Fprintln(w, "  charset: no conversion needed")
+		}
+		c = transcode(c)
+	}
+
+	n := c
+	for _, r := range s.nr {
+		prev := n
+		n = r.Apply(n)
+		switch {
+		case len(n) > len(prev):
+			fmt.Fprintf(w, "  normalizer %s: %d bytes added\n", r.Name, len(n)-len(prev))
+		case len(n) < len(prev):
+			fmt.Fprintf(w, "  normalizer %s: %d bytes removed\n", r.Name, len(prev)-len(n))
+		case !bytes.Equal(n, prev):
+			fmt.Fprintf(w, "  normalizer %s: content rewritten\n", r.Name)
+		default:
+			fmt.Fprintf(w, "  normalizer %s: no change\n", r.Name)
+		}
+	}
+	matchAll("normalized content", n)
+
+	if s.Heuristics > 0 {
+		fmt.Fprintf(w, "  heuristics (level %d):\n", s.Heuristics)
+		verdict(&varCallSignature, checkVarCall(n, s.Heuristics))
+	}
+
+	if s.Decompress {
+		if d, funcs := decompressPayloads(n); len(d) > 0 {
+			d, _ = Normalize(d, s.nr)
+			matchAll(fmt.Sprintf("decompressed payloads %v", funcs), d)
+		} else {
+			fmt.Fprintln(w, "  decompress: no compressed payloads")
+		}
+	}
+
+	if s.Embedded {
+		docs := extractEmbedded(c)
+		if len(docs) == 0 {
+			fmt.Fprintln(w, "  embedded: no inline scripts or data: URIs")
+		}
+		for _, doc := range docs {
+			d, _ := Normalize(doc.content, s.nr)
+			matchAll(fmt.Sprintf("%s at offset %d", doc.kind, doc.offset), d)
+		}
+	}
+
+	if len(s.DecoderCmd) > 0 {
+		d, err := runDecoder(s.DecoderCmd, c, s.DecoderTimeout)
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "  external decoder: %s\n", err)
+		case len(d) == 0:
+			fmt.Fprintln(w, "  external decoder: no output")
+		default:
+			d, _ = Normalize(d, s.nr)
+			matchAll("external decoder output", d)
+		}
+	}
+
+	return flagged
+}
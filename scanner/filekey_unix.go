@@ -0,0 +1,18 @@
+//go:build !windows
+
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileKey returns the device and inode of the file.
+func fileKey(path string, info os.FileInfo) (string, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d:%d", st.Dev, st.Ino), true
+}
@@ -0,0 +1,20 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// fileKey returns the path with the symlinks resolved,
+// since the file info carries no inode here.
+func fileKey(path string, info os.FileInfo) (string, bool) {
+	p, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", false
+	}
+	return abs, true
+}
@@ -0,0 +1,100 @@
+package scanner
+
+import (
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MimeList is a list of accepted content types. An entry ending with "/"
+// matches by prefix ("text/"), an entry starting with "/" matches
+// by suffix ("/xml"), any other entry must be equal to the type.
+type MimeList []string
+
+// Content types scanned when neither Extensions nor ContentTypes are set.
+var DEFAULT_MIME = MimeList{"text/", "/xml"}
+
+// MIME_SNIFF_LEN is the length of the head used to detect the content type.
+const MIME_SNIFF_LEN = 512
+
+// A PHP open tag anywhere in the head makes the content PHP, even if
+// it starts with bytes http.DetectContentType takes for binary data.
+var rePHPOpenTag = regexp.MustCompile(`(?i)<\?(?:php\b|=)`)
+
+func (ml MimeList) String() string {
+	return strings.Join(ml, ",")
+}
+
+// Set appends the comma-separated types, so a MimeList is a flag.Value.
+func (ml *MimeList) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if len(s) > 0 {
+			*ml = append(*ml, s)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the content type, as returned
+// by DetectContentType, is in the list.
+func (ml MimeList) Match(mimeType string) bool {
+	if i := strings.IndexByte(mimeType, ';'); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+
+	for _, m := range ml {
+		switch {
+		case strings.HasSuffix(m, "/"):
+			if strings.HasPrefix(mimeType, m) {
+				return true
+			}
+		case strings.HasPrefix(m, "/"):
+			if strings.HasSuffix(mimeType, m) {
+				return true
+			}
+		case m == mimeType:
+			return true
+		}
+	}
+	return false
+}
+
+// DetectContentType returns the content type of the head of a file.
+func DetectContentType(head []byte) string {
+	if len(head) > MIME_SNIFF_LEN {
+		head = head[:MIME_SNIFF_LEN]
+	}
+	if rePHPOpenTag.Match(head) {
+		return "text/x-php"
+	}
+	return http.DetectContentType(head)
+}
+
+// AcceptName reports whether the file name has one of the Extensions,
+// or any name if there are none.
+func (o *Options) AcceptName(name string) bool {
+	if len(o.Extensions) == 0 {
+		return true
+	}
+	_, ok := o.Extensions[filepath.Ext(name)]
+	return ok
+}
+
+// CheckContentType reports whether the content type has to be checked:
+// always without Extensions, and in addition to them with ContentTypes.
+func (o *Options) CheckContentType() bool {
+	return len(o.Extensions) == 0 || len(o.ContentTypes) > 0
+}
+
+// AcceptContentType reports whether the content type is scanned: it must
+// be in ContentTypes (DEFAULT_MIME if empty) or in AlsoContentTypes.
+func (o *Options) AcceptContentType(mimeType string) bool {
+	accepted := o.ContentTypes
+	if len(accepted) == 0 {
+		accepted = DEFAULT_MIME
+	}
+	return accepted.Match(mimeType) || o.AlsoContentTypes.Match(mimeType)
+}
@@ -0,0 +1,64 @@
+package scanner
+
+import (
+	"testing"
+)
+
+func TestMimeListMatch(t *testing.T) {
+	ml := MimeList{"text/", "/xml", "application/json"}
+	tests := map[string]bool{
+		"text/plain; charset=utf-8": true,
+		"text/x-php":                true,
+		"application/xml":           true,
+		"APPLICATION/JSON":          true,
+		"application/octet-stream":  false,
+		"image/png":                 false,
+	}
+	for mimeType, want := range tests {
+		if got := ml.Match(mimeType); got != want {
+			t.Errorf("Match(%q) = %v, want %v", mimeType, got, want)
+		}
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := map[string]string{
+		"\xef\xbb\xbf<?php echo 1;": "text/x-php",
+		"\x00\x00<?php echo 1;":     "text/x-php",
+		"\x00<?= $x ?>":             "text/x-php",
+		"<?xml version=\"1.0\"?>":   "text/xml; charset=utf-8",
+		"\x00\x01\x02\x03":          "application/octet-stream",
+	}
+	for head, want := range tests {
+		if got := DetectContentType([]byte(head)); got != want {
+			t.Errorf("DetectContentType(%q) = %q, want %q", head, got, want)
+		}
+	}
+}
+
+func TestOptionsFilters(t *testing.T) {
+	var o Options
+	if !o.AcceptName("a.bin") || !o.CheckContentType() {
+		t.Error("no filters: every name is accepted and the content type is checked")
+	}
+	if !o.AcceptContentType("text/plain") || o.AcceptContentType("image/png") {
+		t.Error("no filters: DEFAULT_MIME is not used")
+	}
+
+	o.Extensions = map[string]struct{}{".php": {}}
+	if !o.AcceptName("dir/a.php") || o.AcceptName("a.php.txt") {
+		t.Error("extensions: wrong names accepted")
+	}
+	if o.CheckContentType() {
+		t.Error("extensions alone: the content type is checked")
+	}
+
+	o.ContentTypes = MimeList{"text/x-php"}
+	o.AlsoContentTypes = MimeList{"image/png"}
+	if !o.CheckContentType() {
+		t.Error("extensions and content types: the content type is not checked")
+	}
+	if !o.AcceptContentType("text/x-php") || o.AcceptContentType("text/plain") || !o.AcceptContentType("image/png") {
+		t.Error("content types: wrong types accepted")
+	}
+}
@@ -0,0 +1,94 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// BrokenLinkError is passed to the walk function
+// for a symlink whose target does not exist.
+type BrokenLinkError struct {
+	Path string
+	Err  error
+}
+
+func (e *BrokenLinkError) Error() string {
+	return fmt.Sprintf("broken symlink: %s", e.Err)
+}
+
+// walkFollow is like filepath.Walk but descends into the symlinked
+// directories and passes the symlinked files with the info of their
+// targets. Every directory is walked once, identified by its device
+// and inode, so a link pointing up the tree cannot loop forever.
+func walkFollow(root string, fn filepath.WalkFunc) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkFollowDir(root, info, fn, make(map[string]struct{}))
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walkFollowDir(path string, info os.FileInfo, fn filepath.WalkFunc, visited map[string]struct{}) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	if key, ok := fileKey(path, info); ok {
+		if _, seen := visited[key]; seen {
+			return nil
+		}
+		visited[key] = struct{}{}
+	}
+	if err := fn(path, info, nil); err != nil {
+		return err
+	}
+
+	names, err := readDirNames(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+	for _, name := range names {
+		p := filepath.Join(path, name)
+		fi, err := os.Lstat(p)
+		if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			target, serr := os.Stat(p)
+			if serr != nil {
+				err = &BrokenLinkError{Path: p, Err: serr}
+			} else {
+				fi = target
+			}
+		}
+		if err != nil {
+			if err := fn(p, fi, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := walkFollowDir(p, fi, fn, visited); err != nil {
+			if !fi.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readDirNames(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,44 @@
+package scanner
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// FileSystem is the source of the files to scan.
+type FileSystem interface {
+	Open(path string) (File, error)
+	Walk(root string, fn filepath.WalkFunc) error
+	// Name returns the path as it is shown in the reports
+	Name(path string) string
+}
+
+// File is the subset of *os.File used by the scanner.
+type File interface {
+	io.ReadSeeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// LocalFS is the local file system.
+type LocalFS struct {
+	// Descend into the symlinked directories,
+	// the walk never follows the symlinks otherwise
+	FollowSymlinks bool
+}
+
+func (LocalFS) Open(path string) (File, error) {
+	return os.Open(path)
+}
+
+func (l LocalFS) Walk(root string, fn filepath.WalkFunc) error {
+	if l.FollowSymlinks {
+		return walkFollow(root, fn)
+	}
+	return filepath.Walk(root, fn)
+}
+
+func (LocalFS) Name(path string) string {
+	return path
+}
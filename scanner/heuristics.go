@@ -0,0 +1,64 @@
+package scanner
+
+import (
+	"regexp"
+)
+
+// HEURISTIC_VARCALL_ID is the signature id reported for matches
+// of the variable function call heuristic. Heuristic ids are negative
+// so they never collide with database signatures.
+const HEURISTIC_VARCALL_ID = -1
+
+var (
+	// $f(...), ${'f'}(...), $o->$m(...)
+	reVarCall = regexp.MustCompile(`(?:\$\{[^}]{1,64}\}|\$[A-Za-z_]\w*)\s*\(`)
+	// $$name, ${$name}
+	reVarVar = regexp.MustCompile(`\$\$[A-Za-z_]|\$\{\s*\$`)
+	// $f = 'system'; $f = "assert";
+	reFuncName = regexp.MustCompile(`(?i)\$[A-Za-z_]\w*\s*=\s*['"](?:system|exec|shell_exec|passthru|popen|proc_open|pcntl_exec|assert|eval|create_function|call_user_func(?:_array)?|preg_replace|base64_decode|str_rot13|gzinflate)['"]`)
+	// user-controlled input
+	reRequest = regexp.MustCompile(`\$_(?:GET|POST|REQUEST|COOKIE|SERVER|FILES)\b|\$HTTP_(?:GET|POST)_VARS\b`)
+)
+
+// Minimal scores needed to report a match at each sensitivity level.
+var heuristicThresholds = map[int]int{
+	1: 4, // low: a function name stored in a variable and called with request input
+	2: 3, // medium: a variable call plus a variable-variable or a function name
+	3: 2, // high: any variable call fed by request input
+}
+
+var varCallSignature = Signature{
+	Id:       HEURISTIC_VARCALL_ID,
+	Title:    "heuristic: variable function call with request input",
+	Type:     "s",
+	Severity: SEVERITY_SOFT,
+}
+
+// checkVarCall scores the normalized content for the dynamic call patterns
+// typical for PHP shells: $f = 'system'; $f($_GET['c']);
+// Returns the span of the first variable call if the score reaches
+// the threshold for the given sensitivity, or nil otherwise.
+func checkVarCall(c []byte, sensitivity int) []int {
+	threshold, ok := heuristicThresholds[sensitivity]
+	if !ok || !reRequest.Match(c) {
+		return nil
+	}
+
+	loc := reVarCall.FindIndex(c)
+	if loc == nil {
+		return nil
+	}
+	score := 2
+
+	if reVarVar.Match(c) {
+		score++
+	}
+	if reFuncName.Match(c) {
+		score += 2
+	}
+
+	if score < threshold {
+		return nil
+	}
+	return loc
+}
@@ -0,0 +1,110 @@
+package scanner
+
+import (
+	"strings"
+	"testing"
+)
+
+// Samples of the dynamic call shells seen in the wild, reduced
+// to the lines that matter, and code of the same shape from CMS trees.
+var varCallSamples = []struct {
+	name    string
+	content string
+	// The lowest sensitivity the sample is reported at, 0 for never
+	want int
+}{
+	{
+		"function name in a variable",
+		`<?php $f = 'system'; $f($_GET['c']);`,
+		1,
+	},
+	{
+		"assert via cookie",
+		`<?php $a = "assert"; @$a(stripslashes($_COOKIE['x']));`,
+		1,
+	},
+	{
+		"variable-variable built from request",
+		`<?php $k = $_POST['k']; $$k = $_POST['v']; $h($_POST['p']);`,
+		2,
+	},
+	{
+		"brace call",
+		`<?php ${'fn'}($_REQUEST['cmd']);`,
+		3,
+	},
+	{
+		"callback from request",
+		`<?php $cb = $_GET['cb']; echo $cb($_GET['arg']);`,
+		3,
+	},
+	{
+		"dispatcher without request input",
+		`<?php $handler = $this->routes[$name]; return $handler($args);`,
+		0,
+	},
+	{
+		"request input without variable calls",
+		`<?php $id = (int) $_GET['id']; echo htmlspecialchars($id);`,
+		0,
+	},
+}
+
+func TestCheckVarCall(t *testing.T) {
+	for _, tt := range varCallSamples {
+		for sensitivity := 1; sensitivity <= 3; sensitivity++ {
+			loc := checkVarCall([]byte(tt.content), sensitivity)
+			want := tt.want > 0 && sensitivity >= tt.want
+			if (loc != nil) != want {
+				t.Errorf("%s: sensitivity %d: reported %v, want %v", tt.name, sensitivity, loc != nil, want)
+			}
+		}
+	}
+}
+
+func TestCheckVarCallSpan(t *testing.T) {
+	c := `<?php $f = 'system'; $f($_GET['c']);`
+	loc := checkVarCall([]byte(c), 1)
+	if loc == nil {
+		t.Fatal("no match")
+	}
+	if got, want := c[loc[0]:loc[1]], "$f("; got != want {
+		t.Errorf("span %q, want %q", got, want)
+	}
+	if loc[0] != strings.Index(c, "$f(") {
+		t.Errorf("offset %d, want the first call", loc[0])
+	}
+}
+
+func TestCheckVarCallSensitivity(t *testing.T) {
+	c := []byte(`<?php $f = 'system'; $f($_GET['c']);`)
+	for _, sensitivity := range []int{-1, 0, 4} {
+		if loc := checkVarCall(c, sensitivity); loc != nil {
+			t.Errorf("sensitivity %d: reported", sensitivity)
+		}
+	}
+}
+
+func TestScannerHeuristics(t *testing.T) {
+	db := compileTestDatabase(t, `never_matching_marker`)
+	nr, _ := CompileNormalizers()
+	c := []byte(`<?php $f = 'system'; $f($_GET['c']);`)
+
+	s := NewScanner(db, nr)
+	if ms := s.ScanBytes(c); len(ms) != 0 {
+		t.Errorf("heuristics disabled: got %v", ms)
+	}
+
+	s = NewScanner(db, nr)
+	s.Heuristics = 1
+	ms := s.ScanBytes(c)
+	if len(ms) != 1 || ms[0].Id != HEURISTIC_VARCALL_ID || ms[0].Type != SEVERITY_SOFT {
+		t.Errorf("heuristics enabled: got %v", ms)
+	}
+
+	// Suppressed like a database signature
+	s.Suppress = func(id int, path string) bool { return id == HEURISTIC_VARCALL_ID }
+	if ms := s.ScanBytes(c); len(ms) != 0 {
+		t.Errorf("suppressed: got %v", ms)
+	}
+}
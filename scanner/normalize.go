@@ -0,0 +1,207 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+// MIN_ENCODED_LEN is the shortest string literal the decoding stages
+// look at. Shorter ones rarely hide anything but a word or two.
+const MIN_ENCODED_LEN = 16
+
+// Normalizer actions
+const (
+	NORMALIZE_DELETE       = "delete"       // remove the matches
+	NORMALIZE_REPLACE      = "replace"      // replace the matches, $1 etc. are expanded
+	NORMALIZE_UNQUOTE      = "unquote"      // replace the escape sequences with the characters
+	NORMALIZE_BASE64       = "base64"       // append the first submatch decoded from base64
+	NORMALIZE_URLDECODE    = "urldecode"    // append the first submatch decoded like urldecode()
+	NORMALIZE_RAWURLDECODE = "rawurldecode" // append the first submatch decoded like rawurldecode()
+)
+
+// NormalizerDef describes a normalization stage in the database.
+type NormalizerDef struct {
+	Name    string `xml:"name,attr" json:"name"`
+	Action  string `xml:"action,attr" json:"action"`
+	Replace string `xml:"replace,attr" json:"replace,omitempty"`
+	Pattern string `xml:",chardata" json:"pattern"`
+}
+
+// DefaultNormalizers undo the common obfuscation tricks. They are used
+// unless the database defines its own. The first stages rewrite the
+// content in place. The decoding stages run last, so the literals
+// are already glued together and unescaped, and they keep the content
+// as is and append the decoded payloads to it: the call that wraps
+// a payload is often what the signatures look for.
+var DefaultNormalizers = []NormalizerDef{
+	{Name: "concat", Action: NORMALIZE_DELETE, Pattern: `(?si:[\'"]\s*?\.\s*?[\'"])`},
+	{Name: "comment", Action: NORMALIZE_DELETE, Pattern: `(?si:/\*.*?\*/)`},
+	{Name: "hex", Action: NORMALIZE_UNQUOTE, Pattern: `(?i:\\x([a-fA-F0-9]{1,2}))`},
+	{Name: "octal", Action: NORMALIZE_UNQUOTE, Pattern: `\\([0-9]{1,3})`},
+	{Name: "base64", Action: NORMALIZE_BASE64, Pattern: `(?i:base64_decode\s*\(\s*['"]([A-Za-z0-9+/]+={0,2})['"]\s*\))`},
+	{Name: "urlencode", Action: NORMALIZE_URLDECODE, Pattern: `(?i:\burldecode\s*\(\s*['"]([^'"]*%[0-9a-fA-F]{2}[^'"]*)['"]\s*\))`},
+	{Name: "urlencode", Action: NORMALIZE_RAWURLDECODE, Pattern: `(?i:rawurldecode\s*\(\s*['"]([^'"]*%[0-9a-fA-F]{2}[^'"]*)['"]\s*\))`},
+}
+
+// Normalizer is a single named normalization stage.
+type Normalizer struct {
+	Name  string
+	apply func(c []byte) []byte
+}
+
+// Apply returns the content transformed by the stage.
+func (n Normalizer) Apply(c []byte) []byte {
+	return n.apply(c)
+}
+
+// Compile prepares the stage described by the definition.
+func (d NormalizerDef) Compile() (Normalizer, error) {
+	r, err := regexp.Compile(d.Pattern)
+	if err != nil {
+		return Normalizer{}, err
+	}
+
+	var fn func([]byte) []byte
+	switch d.Action {
+	case NORMALIZE_DELETE:
+		fn = replace(r, nil)
+	case NORMALIZE_REPLACE:
+		fn = replace(r, []byte(d.Replace))
+	case NORMALIZE_UNQUOTE:
+		fn = unescape(r)
+	case NORMALIZE_BASE64, NORMALIZE_URLDECODE, NORMALIZE_RAWURLDECODE:
+		if r.NumSubexp() == 0 {
+			return Normalizer{}, fmt.Errorf("the %s action needs a submatch with the payload", d.Action)
+		}
+		decode := decodeBase64
+		switch d.Action {
+		case NORMALIZE_URLDECODE:
+			decode = decodeURL(url.QueryUnescape)
+		case NORMALIZE_RAWURLDECODE:
+			// rawurldecode keeps the plus signs
+			decode = decodeURL(url.PathUnescape)
+		}
+		fn = decodeAppend(r, decode)
+	default:
+		return Normalizer{}, fmt.Errorf("unknown action %q", d.Action)
+	}
+	return Normalizer{Name: d.Name, apply: fn}, nil
+}
+
+// CompileNormalizers returns the default normalization stages.
+func CompileNormalizers() ([]Normalizer, error) {
+	return compileNormalizers(DefaultNormalizers)
+}
+
+func compileNormalizers(defs []NormalizerDef) ([]Normalizer, error) {
+	compiled := make([]Normalizer, 0, len(defs))
+	for i, d := range defs {
+		n, err := d.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile normalizer %d (%s) regexp %q: %v", i+1, d.Name, d.Pattern, err)
+		}
+		compiled = append(compiled, n)
+	}
+	return compiled, nil
+}
+
+// Normalize returns a copy of the content with the normalizers applied
+// and the names of the normalizers that changed it.
+func Normalize(c []byte, nr []Normalizer) ([]byte, []string) {
+	var applied []string
+	for _, r := range nr {
+		prev := c
+		c = r.Apply(c)
+		// Most stages change the length when they apply,
+		// only a replacement may keep it
+		if len(c) != len(prev) || !bytes.Equal(c, prev) {
+			applied = append(applied, r.Name)
+		}
+	}
+	return c, applied
+}
+
+// replace replaces the matches of r with the template.
+func replace(r *regexp.Regexp, tmpl []byte) func([]byte) []byte {
+	return func(c []byte) []byte {
+		return r.ReplaceAll(c, tmpl)
+	}
+}
+
+// unescape replaces the escape sequences matched by r with the characters.
+func unescape(r *regexp.Regexp) func([]byte) []byte {
+	return func(c []byte) []byte {
+		return r.ReplaceAllFunc(c, unquoteStr)
+	}
+}
+
+func unquoteStr(s []byte) []byte {
+	u, err := strconv.Unquote("'" + string(s) + "'")
+	if err != nil {
+		return []byte{}
+	}
+	return []byte(u)
+}
+
+// decodeAppend appends the payloads decoded from the first submatches
+// of r to a copy of the content, each on its own line. The decode
+// function returns nil if there is nothing to append.
+func decodeAppend(r *regexp.Regexp, decode func(payload []byte) []byte) func([]byte) []byte {
+	return func(c []byte) []byte {
+		var out []byte
+		for _, sub := range r.FindAllSubmatch(c, -1) {
+			if len(sub[1]) < MIN_ENCODED_LEN {
+				continue
+			}
+			d := decode(sub[1])
+			if d == nil || !isPlainText(d) {
+				continue
+			}
+			if out == nil {
+				out = append(make([]byte, 0, len(c)+len(d)+1), c...)
+			}
+			out = append(append(out, '\n'), d...)
+		}
+		if out == nil {
+			return c
+		}
+		return out
+	}
+}
+
+func decodeBase64(payload []byte) []byte {
+	d, err := base64.RawStdEncoding.DecodeString(string(bytes.TrimRight(payload, "=")))
+	if err != nil {
+		return nil
+	}
+	return d
+}
+
+func decodeURL(unescape func(string) (string, error)) func([]byte) []byte {
+	return func(payload []byte) []byte {
+		d, err := unescape(string(payload))
+		if err != nil {
+			return nil
+		}
+		return []byte(d)
+	}
+}
+
+// isPlainText reports whether the decoded payload looks like source code
+// rather than random bytes that happened to be valid base64.
+func isPlainText(b []byte) bool {
+	if len(b) == 0 || !utf8.Valid(b) {
+		return false
+	}
+	for _, c := range b {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package scanner
+
+import (
+	"bytes"
+	"regexp/syntax"
+	"strings"
+)
+
+// MIN_LITERAL_LEN is the shortest literal worth checking before
+// the regexp. Shorter ones are found in almost every file.
+const MIN_LITERAL_LEN = 3
+
+// prefilter holds the literals that must occur in the content
+// for the regexp of a signature to match. The content is checked
+// for each distinct literal once, and the regexps of the signatures
+// whose literal is missing are not run at all.
+type prefilter struct {
+	literals [][]byte
+
+	// Index of the literal of each signature of the database,
+	// -1 if no literal could be extracted
+	index []int
+}
+
+// newPrefilter extracts the required literals of the signatures of db.
+func newPrefilter(db *Database) *prefilter {
+	pf := &prefilter{index: make([]int, len(db.Signatures))}
+	ids := make(map[string]int)
+
+	for i, sig := range db.Signatures {
+		pf.index[i] = -1
+		if sig.Regexp == nil {
+			continue
+		}
+		re, err := syntax.Parse(sig.Signature, syntax.Perl)
+		if err != nil {
+			continue
+		}
+		lit := requiredLiteral(re.Simplify())
+		if len(lit) < MIN_LITERAL_LEN {
+			continue
+		}
+		lit = strings.ToLower(lit)
+		id, ok := ids[lit]
+		if !ok {
+			id = len(pf.literals)
+			ids[lit] = id
+			pf.literals = append(pf.literals, []byte(lit))
+		}
+		pf.index[i] = id
+	}
+	return pf
+}
+
+// requiredLiteral returns the longest ASCII literal that every match
+// of the expression contains, or an empty string if there is none.
+func requiredLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r >= 0x80 {
+				return ""
+			}
+		}
+		return string(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiteral(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		var longest string
+		for _, sub := range re.Sub {
+			if lit := requiredLiteral(sub); len(lit) > len(longest) {
+				longest = lit
+			}
+		}
+		return longest
+	}
+	return ""
+}
+
+// filter returns a function reporting whether the regexp of the i-th
+// signature may match the content c. The literals are looked up lazily
+// and the results are shared by the signatures with the same literal.
+func (pf *prefilter) filter(c []byte) func(i int) bool {
+	var folded []byte
+	found := make([]int8, len(pf.literals))
+
+	return func(i int) bool {
+		id := pf.index[i]
+		if id < 0 {
+			return true
+		}
+		if found[id] == 0 {
+			if folded == nil {
+				folded = foldCase(c)
+			}
+			found[id] = -1
+			if bytes.Contains(folded, pf.literals[id]) {
+				found[id] = 1
+			}
+		}
+		return found[id] > 0
+	}
+}
+
+// foldCase lowercases the ASCII letters of c. The only non-ASCII
+// characters that match an ASCII letter case-insensitively, the long s
+// and the Kelvin sign, are replaced with the letters, so the folded
+// content contains a literal whenever the regexp could match it.
+func foldCase(c []byte) []byte {
+	f := make([]byte, len(c))
+	ascii := true
+	for i, b := range c {
+		switch {
+		case b >= 'A' && b <= 'Z':
+			b += 'a' - 'A'
+		case b >= 0x80:
+			ascii = false
+		}
+		f[i] = b
+	}
+	if !ascii {
+		f = bytes.Replace(f, []byte("\u017f"), []byte("s"), -1)
+		f = bytes.Replace(f, []byte("\u212a"), []byte("k"), -1)
+	}
+	return f
+}
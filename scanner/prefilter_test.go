@@ -0,0 +1,242 @@
+package scanner
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"regexp/syntax"
+	"strings"
+	"testing"
+	"time"
+)
+
+func literalOf(t *testing.T, pattern string) string {
+	t.Helper()
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		t.Fatalf("cannot parse %q: %s", pattern, err)
+	}
+	return requiredLiteral(re.Simplify())
+}
+
+func TestRequiredLiteral(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		// The longest literal of a concatenation
+		{`eval\s*\(\s*\$_POST`, "$_POST"},
+		{`base64_decode\s*\(`, "base64_decode"},
+		{`(?:evil){2}shell`, "shell"},
+		{`x{0,3}assert`, "assert"},
+		{`(eval)?assert`, "assert"},
+		{`(?:preg_replace)+`, "preg_replace"},
+
+		// Alternations only contribute their common prefix
+		{`foo(bar|baz)qux`, "foo"},
+		{`(system|exec)\(`, "("},
+		{`abcdef|abcxyz`, "abc"},
+		{`passthru|shell_exec`, ""},
+
+		// No literal is required
+		{`a*`, ""},
+		{`.*`, ""},
+		{`[a-z]+`, ""},
+		{`(?:eval)?`, ""},
+		{`(?:eval){0,2}`, ""},
+
+		// Non-ASCII literals are not extracted, but with (?i)
+		// the parser folds the long s into its ASCII case class
+		{`ſystem`, ""},
+		{`(?i)ſystem`, "SYSTEM"},
+		{`(?i)\x{212a}ill`, "KILL"},
+	}
+	for _, tt := range tests {
+		if got := literalOf(t, tt.pattern); got != tt.want {
+			t.Errorf("requiredLiteral(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestRequiredLiteralFoldCase(t *testing.T) {
+	if got := strings.ToLower(literalOf(t, `(?i)Base64_Decode\s*\(`)); got != "base64_decode" {
+		t.Errorf("got %q, want base64_decode", got)
+	}
+}
+
+func compileTestDatabase(t testing.TB, patterns ...string) *Database {
+	t.Helper()
+	db := &Database{}
+	for i, p := range patterns {
+		db.Signatures = append(db.Signatures, Signature{Id: i + 1, Title: fmt.Sprintf("sig-%d", i+1), Type: "c", Signature: p})
+	}
+	db, err := prepareDatabase(db, LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPrefilterFoldCase(t *testing.T) {
+	db := compileTestDatabase(t, `(?i)system\s*\(`, `(?i)kill\s*\(`, `(?i)eval\s*\(`)
+	pf := newPrefilter(db)
+
+	tests := []struct {
+		content string
+		want    []bool
+	}{
+		{"SYSTEM($x); KILL(1);", []bool{true, true, false}},
+		// The long s and the Kelvin sign match s and k case-insensitively
+		{"ſystem($x);", []bool{true, false, false}},
+		{"Kill(1);", []bool{false, true, false}},
+		{"echo 'nothing here';", []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		mayMatch := pf.filter([]byte(tt.content))
+		for i, sig := range db.Signatures {
+			if got := mayMatch(i); got != tt.want[i] {
+				t.Errorf("filter(%q) for %q = %v, want %v", tt.content, sig.Signature, got, tt.want[i])
+			}
+			// The prefilter must never reject what the regexp matches
+			if sig.Regexp.MatchString(tt.content) && !mayMatch(i) {
+				t.Errorf("filter(%q) rejects %q which matches", tt.content, sig.Signature)
+			}
+		}
+	}
+}
+
+func TestPrefilterShortLiteral(t *testing.T) {
+	db := compileTestDatabase(t, `(system|exec)\(`, `a*`)
+	pf := newPrefilter(db)
+	for i := range db.Signatures {
+		if pf.index[i] != -1 {
+			t.Errorf("signature %q has literal %q, want none", db.Signatures[i].Signature, pf.literals[pf.index[i]])
+		}
+	}
+}
+
+// Signatures modelled on the manul database: a few dozen real-looking
+// rules and a long tail of rules keyed by the names of known shells.
+func benchmarkPatterns() []string {
+	patterns := []string{
+		`eval\s*\(\s*\$_(?:GET|POST|REQUEST|COOKIE)`,
+		`eval\s*\(\s*base64_decode\s*\(`,
+		`eval\s*\(\s*gzinflate\s*\(`,
+		`eval\s*\(\s*str_rot13\s*\(`,
+		`assert\s*\(\s*\$_(?:GET|POST|REQUEST)`,
+		`preg_replace\s*\(\s*['"]/.*/e['"]`,
+		`create_function\s*\(\s*['"]['"]\s*,`,
+		`(?i)passthru\s*\(\s*\$_`,
+		`(?i)shell_exec\s*\(\s*\$_`,
+		`(?i)system\s*\(\s*\$_(?:GET|POST|REQUEST)`,
+		`(?i)FilesMan`,
+		`(?i)c99shell`,
+		`(?i)r57shell`,
+		`(?i)WSO\s+[0-9.]+`,
+		`move_uploaded_file\s*\(\s*\$_FILES\[.{1,40}\]\[['"]tmp_name['"]\]\s*,\s*\$_(?:GET|POST)`,
+		`\$GLOBALS\[['"][a-z0-9_]+['"]\]\s*\(\s*\$GLOBALS`,
+		`chr\(\d+\)\s*\.\s*chr\(\d+\)\s*\.\s*chr\(\d+\)\s*\.\s*chr\(\d+\)`,
+		`\\x[0-9a-f]{2}\\x[0-9a-f]{2}\\x[0-9a-f]{2}\\x[0-9a-f]{2}\\x[0-9a-f]{2}`,
+	}
+	for i := 0; i < 400; i++ {
+		patterns = append(patterns, fmt.Sprintf(`(?i)shell_variant_%03d\s*\(\s*\$\w+`, i))
+	}
+	return patterns
+}
+
+// benchmarkCorpus returns PHP files resembling a CMS tree,
+// a few of them infected.
+func benchmarkCorpus() [][]byte {
+	rnd := rand.New(rand.NewSource(1))
+	lines := []string{
+		"<?php",
+		"/**\n * Retrieves the post meta field for the given post ID.\n */",
+		"function get_post_meta_value( $post_id, $key = '', $single = false ) {",
+		"\t$meta = get_metadata( 'post', $post_id, $key, $single );",
+		"\tif ( empty( $meta ) ) { return apply_filters( 'default_post_metadata', null, $post_id ); }",
+		"\treturn $meta;",
+		"}",
+		"$wpdb->query( $wpdb->prepare( \"SELECT * FROM {$wpdb->posts} WHERE ID = %d\", $id ) );",
+		"echo esc_html( sprintf( __( 'Updated %s ago', 'textdomain' ), human_time_diff( $time ) ) );",
+		"add_action( 'init', array( $this, 'register_post_types' ), 10, 0 );",
+		"$options = wp_parse_args( $args, array( 'number' => 10, 'offset' => 0 ) );",
+		"if ( isset( $_GET['page'] ) && 'settings' === $_GET['page'] ) { $this->render(); }",
+		"$html .= '<div class=\"' . esc_attr( $class ) . '\">' . $content . '</div>';",
+		"// TODO: cache the result of the query per request",
+	}
+	infections := []string{
+		"eval(base64_decode('ZXZhbCgkX1BPU1RbJ2MnXSk7'));",
+		"@preg_replace('/.*/e', $_POST['x'], '');",
+		"$f = 'sys' . 'tem'; if (isset($_REQUEST['cmd'])) { shell_variant_123($cmd); }",
+	}
+
+	var files [][]byte
+	for i := 0; i < 200; i++ {
+		var b strings.Builder
+		for b.Len() < 16*1024 {
+			b.WriteString(lines[rnd.Intn(len(lines))])
+			b.WriteByte('\n')
+		}
+		if i%40 == 0 {
+			b.WriteString(infections[(i/40)%len(infections)])
+			b.WriteByte('\n')
+		}
+		files = append(files, []byte(b.String()))
+	}
+	return files
+}
+
+func TestPrefilterSameMatches(t *testing.T) {
+	db := compileTestDatabase(t, benchmarkPatterns()...)
+	nr, _ := CompileNormalizers()
+
+	with := NewScanner(db, nr)
+	without := NewScanner(db, nr)
+	without.NoPrefilter = true
+
+	for i, c := range benchmarkCorpus() {
+		// The infected files and their neighbours are enough,
+		// scanning without the prefilter is slow
+		if i%40 > 1 {
+			continue
+		}
+		a, b := with.ScanBytes(c), without.ScanBytes(c)
+		for j := range a {
+			a[j].Time = time.Time{}
+		}
+		for j := range b {
+			b[j].Time = time.Time{}
+		}
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("file %d: matches with the prefilter %v, without %v", i, a, b)
+		}
+	}
+}
+
+func BenchmarkScanPrefilter(b *testing.B) {
+	db := compileTestDatabase(b, benchmarkPatterns()...)
+	nr, _ := CompileNormalizers()
+	corpus := benchmarkCorpus()
+
+	var size int64
+	for _, c := range corpus {
+		size += int64(len(c))
+	}
+
+	for _, noPrefilter := range []bool{false, true} {
+		name := "prefilter"
+		if noPrefilter {
+			name = "no-prefilter"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := NewScanner(db, nr)
+			s.NoPrefilter = noPrefilter
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				for _, c := range corpus {
+					s.ScanBytes(c)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+// Package scanner implements the matching of files against
+// the manul malware signature databases.
+//
+// It is imported as github.com/0xef53/rigel/scanner, the module
+// declared in the go.mod of the repository.
+package scanner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// MAXFILESIZE is the default size of the content that is matched
+// at once. Larger content is streamed in windows.
+const MAXFILESIZE = 2 * 1024 * 1024 // 2M
+
+// DecoderError is returned when the external decoder fails.
+// The matches found before the decoder ran are returned with it.
+type DecoderError struct {
+	Err error
+}
+
+func (e *DecoderError) Error() string {
+	return e.Err.Error()
+}
+
+// Match describes a single signature match.
+type Match struct {
+	Path  string   `json:"path"`
+	Id    int      `json:"id"`
+	Title string   `json:"title"`
+	Type  Severity `json:"type"`
+
+	// The database the signature comes from
+	Source string `json:"source,omitempty"`
+
+	// Time the file was scanned
+	Time time.Time `json:"time"`
+
+	// Span of the matched region in the normalized content
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+
+	// SHA-256 of the raw file content, only set if Scanner.Hash is enabled
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Shannon entropy of the raw file content in bits per byte,
+	// only set if Scanner.Entropy is enabled
+	Entropy *float64 `json:"entropy,omitempty"`
+
+	// Decoding steps that changed the content before it matched
+	Decoders []string `json:"decoders,omitempty"`
+
+	// Offset of the inline script or data: URI the match was found in,
+	// the span above is relative to its normalized content then
+	Embedded *int `json:"embedded_at,omitempty"`
+
+	// The line of the match in the normalized content, the offset
+	// of the matched text in the raw content if it can be found there,
+	// and the matched region with some context around it. They are only
+	// set if Scanner.ContextRadius is positive
+	Line      int    `json:"line,omitempty"`
+	RawOffset *int   `json:"raw_offset,omitempty"`
+	Context   string `json:"context,omitempty"`
+
+	// The head of the matched text, used to find it in the raw content
+	region []byte
+}
+
+// Options enable the optional detection stages of a Scanner.
+type Options struct {
+	// Convert the content to UTF-8 according to its BOM or declared charset
+	DetectEncoding bool
+
+	// Sensitivity of the variable function call heuristic, 0 disables it
+	Heuristics int
+
+	// Match the payloads wrapped into PHP decompression calls
+	Decompress bool
+
+	// Match the inline scripts and data: URIs as separate documents
+	Embedded bool
+
+	// External decoder command and the time limit for a single run
+	DecoderCmd     string
+	DecoderTimeout time.Duration
+
+	// Compute the entropy of the matched content
+	Entropy bool
+
+	// Compute the digest of the matched content
+	Hash bool
+
+	// Stop at the first matching signature instead of reporting all
+	FirstMatch bool
+
+	// Content larger than MaxSize bytes is matched in overlapping windows
+	// of READER_BLOCKSIZE instead of at once, 0 means always stream
+	MaxSize int64
+
+	// Overlap between the windows of a streamed file in bytes. A longer
+	// overlap lets longer matches straddle the block boundary, but every
+	// window rescans it. 0 derives it from the signatures, see windowOverlap
+	Overlap int
+
+	// Number of bytes of context around the match in Match.Context
+	// and the limit of its total size, 0 means no limit
+	ContextRadius int
+	ContextLimit  int
+
+	// Run the regexps of all signatures, even of those whose required
+	// literal is not in the content. Only useful to debug the prefilter
+	NoPrefilter bool
+
+	// If set, a match of the signature id in the path is skipped
+	// when it returns true, so the other signatures still have a chance
+	Suppress func(id int, path string) bool
+
+	// Extensions of the file names to scan with the leading dot,
+	// and the content types to scan, see AcceptName and AcceptContentType.
+	// The scan methods do not check them, they are meant for the code
+	// choosing the files to scan
+	Extensions       map[string]struct{}
+	ContentTypes     MimeList
+	AlsoContentTypes MimeList
+}
+
+// DefaultOptions returns the options of a new Scanner.
+func DefaultOptions() Options {
+	return Options{
+		DecoderTimeout: 10 * time.Second,
+		MaxSize:        MAXFILESIZE,
+	}
+}
+
+// Scanner matches content against the signatures of a database.
+// The options must be set before the first scan. A Scanner is safe
+// for concurrent use, and scanners with different options and
+// databases can be used side by side.
+type Scanner struct {
+	Options
+
+	db *Database
+	nr []Normalizer
+	pf *prefilter
+
+	// Overlap between the windows of a streamed file
+	overlap int
+}
+
+// NewScanner returns a scanner for the signatures of db with the
+// DefaultOptions. The normalizers are usually created by
+// Database.CompileNormalizers.
+func NewScanner(db *Database, normalizers []Normalizer) *Scanner {
+	return &Scanner{
+		Options: DefaultOptions(),
+		db:      db,
+		nr:      normalizers,
+		pf:      newPrefilter(db),
+		overlap: windowOverlap(db),
+	}
+}
+
+// ScanFile reads the local file and matches its content.
+func (s *Scanner) ScanFile(path string) ([]Match, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return s.ScanReader(f, path)
+}
+
+// Scan is an alias for ScanFile.
+//
+// Deprecated: use ScanFile.
+func (s *Scanner) Scan(path string) ([]Match, error) {
+	return s.ScanFile(path)
+}
+
+// ScanBytes matches the content without touching the filesystem.
+// The signatures with a name condition never match it.
+func (s *Scanner) ScanBytes(c []byte) []Match {
+	ms, _ := s.match("", c)
+	return ms
+}
+
+// ScanReader reads the content from r and matches it against
+// the signatures. The name is the path reported in the matches.
+// The content larger than MaxSize is streamed.
+func (s *Scanner) ScanReader(r io.Reader, name string) ([]Match, error) {
+	if s.MaxSize <= 0 {
+		return s.matchStream(name, r)
+	}
+
+	c, err := ioutil.ReadAll(io.LimitReader(r, s.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(c)) > s.MaxSize {
+		return s.matchStream(name, io.MultiReader(bytes.NewReader(c), r))
+	}
+	return s.match(name, c)
+}
+
+// match runs the detection stages in order and returns the matches
+// of all signatures. Each signature is reported once, at the first
+// stage it matches.
+func (s *Scanner) match(name string, c []byte) (ms []Match, err error) {
+	seen := make(map[int]bool)
+
+	if s.ContextRadius > 0 {
+		raw := c
+		defer func() { locateRaw(ms, raw) }()
+	}
+
+	// The fields shared by all matches of this file
+	tmpl := Match{Path: name, Time: time.Now()}
+
+	// Entropy is computed from the raw content only on request,
+	// since it costs an extra pass over every file
+	if s.Entropy {
+		e := entropy(c)
+		tmpl.Entropy = &e
+	}
+
+	// Whole-file digests are compared against the raw content
+	var digest string
+	sum := func() string {
+		if len(digest) == 0 {
+			h := sha256.Sum256(c)
+			digest = hex.EncodeToString(h[:])
+		}
+		return digest
+	}
+	if s.Hash {
+		tmpl.SHA256 = sum()
+	}
+	for i := range s.db.Signatures {
+		if s.done(seen) {
+			return ms, nil
+		}
+		sig := &s.db.Signatures[i]
+		if sig.Mode == MODE_EQUALS && sig.MatchName(name) && sig.MatchRaw(c, sum) && !s.suppressed(sig, name) {
+			ms = append(ms, s.newMatch(tmpl, sig, c, []int{0, len(c)}))
+			seen[sig.Id] = true
+		}
+	}
+
+	if s.DetectEncoding {
+		if t := transcode(c); !bytes.Equal(t, c) {
+			c = t
+			tmpl.Decoders = append(tmpl.Decoders, "charset")
+		}
+	}
+
+	ms = append(ms, s.matchContent(tmpl, c, seen, false)...)
+
+	// The output of the external decoder is matched the same way
+	if len(s.DecoderCmd) > 0 && !s.done(seen) {
+		d, err := runDecoder(s.DecoderCmd, c, s.DecoderTimeout)
+		if err != nil {
+			return ms, &DecoderError{err}
+		}
+		if len(d) > 0 {
+			n, applied := Normalize(d, s.nr)
+			ms = append(ms, s.matchSignatures(withDecoders(tmpl, append([]string{"external"}, applied...)...), n, seen, false)...)
+		}
+	}
+
+	return ms, nil
+}
+
+// matchContent runs the stages that work on the content alone:
+// the normalized signatures, the heuristics, the decompressed payloads
+// and the embedded documents. With partial the content is a window
+// of a larger file, so the anchored signatures cannot match it.
+func (s *Scanner) matchContent(tmpl Match, c []byte, seen map[int]bool, partial bool) []Match {
+	name := tmpl.Path
+
+	n, applied := Normalize(c, s.nr)
+	ms := s.matchSignatures(withDecoders(tmpl, applied...), n, seen, partial)
+
+	if s.Heuristics > 0 && !seen[HEURISTIC_VARCALL_ID] && !s.done(seen) {
+		if loc := checkVarCall(n, s.Heuristics); loc != nil && !s.suppressed(&varCallSignature, name) {
+			ms = append(ms, s.newMatch(withDecoders(tmpl, applied...), &varCallSignature, n, loc))
+			seen[HEURISTIC_VARCALL_ID] = true
+		}
+	}
+
+	// Payloads wrapped into PHP decompression calls
+	if s.Decompress && !s.done(seen) {
+		if d, funcs := decompressPayloads(n); len(d) > 0 {
+			n, applied := Normalize(d, s.nr)
+			ms = append(ms, s.matchSignatures(withDecoders(tmpl, append(funcs, applied...)...), n, seen, partial)...)
+		}
+	}
+
+	// Inline scripts and data: URIs are matched as separate documents,
+	// so the surrounding markup does not get in the way
+	if s.Embedded {
+		for _, doc := range extractEmbedded(c) {
+			if s.done(seen) {
+				break
+			}
+			n, applied := Normalize(doc.content, s.nr)
+			t := withDecoders(tmpl, append([]string{doc.kind}, applied...)...)
+			offset := doc.offset
+			t.Embedded = &offset
+			ms = append(ms, s.matchSignatures(t, n, seen, partial)...)
+		}
+	}
+
+	return ms
+}
+
+// withDecoders returns a copy of the match template
+// with the decoding steps appended.
+func withDecoders(tmpl Match, decoders ...string) Match {
+	tmpl.Decoders = append(append([]string{}, tmpl.Decoders...), decoders...)
+	return tmpl
+}
+
+// matchSignatures returns the matches of the regexp signatures
+// in the normalized content c, skipping the ones already seen.
+// The tmpl holds the fields of the match that do not depend
+// on the signature. With partial the anchored signatures are skipped.
+func (s *Scanner) matchSignatures(tmpl Match, c []byte, seen map[int]bool, partial bool) []Match {
+	var ms []Match
+	mayMatch := s.pf.filter(c)
+	for i := range s.db.Signatures {
+		if s.done(seen) {
+			break
+		}
+		sig := &s.db.Signatures[i]
+		if sig.Regexp == nil || seen[sig.Id] || !sig.MatchName(tmpl.Path) {
+			continue
+		}
+		if partial && sig.Mode == MODE_ANCHORED {
+			continue
+		}
+		if !s.NoPrefilter && !mayMatch(i) {
+			continue
+		}
+		if loc := sig.Regexp.FindIndex(c); loc != nil && !s.suppressed(sig, tmpl.Path) {
+			ms = append(ms, s.newMatch(tmpl, sig, c, loc))
+			seen[sig.Id] = true
+		}
+	}
+	return ms
+}
+
+// done reports whether the scan of a file can stop early.
+func (s *Scanner) done(seen map[int]bool) bool {
+	return s.FirstMatch && len(seen) > 0
+}
+
+func (s *Scanner) suppressed(sig *Signature, name string) bool {
+	return s.Suppress != nil && s.Suppress(sig.Id, name)
+}
+
+// newMatch fills the template with the signature s matched
+// at the span loc of the content c.
+func (s *Scanner) newMatch(m Match, sig *Signature, c []byte, loc []int) Match {
+	m.Id = sig.Id
+	m.Title = sig.Title
+	m.Type = sig.Severity
+	m.Source = sig.Source
+	m.Offset = loc[0]
+	m.Length = loc[1] - loc[0]
+	if s.ContextRadius > 0 {
+		m.Context = Snippet(c, loc, s.ContextRadius, s.ContextLimit)
+		m.Line = bytes.Count(c[:loc[0]], []byte{'\n'}) + 1
+		region := c[loc[0]:loc[1]]
+		if len(region) > MAX_REGION {
+			region = region[:MAX_REGION]
+		}
+		m.region = append([]byte{}, region...)
+	}
+	return m
+}
+
+// MAX_REGION is the length of the head of the matched text
+// looked up in the raw content.
+const MAX_REGION = 64
+
+// locateRaw sets the offsets of the matches in the raw content c.
+// The normalizers change the offsets, so the matched text is looked up
+// in c, which only works if the normalizers did not change it too.
+func locateRaw(ms []Match, c []byte) {
+	for i := range ms {
+		m := &ms[i]
+		switch {
+		case m.region == nil:
+			continue
+		case len(m.Decoders) == 0 && m.Embedded == nil:
+			offset := m.Offset
+			m.RawOffset = &offset
+		case len(m.region) > 0:
+			if offset := bytes.Index(c, m.region); offset >= 0 {
+				m.RawOffset = &offset
+			}
+		}
+		m.region = nil
+	}
+}
@@ -0,0 +1,166 @@
+package scanner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testScanner(t *testing.T) *Scanner {
+	t.Helper()
+	db, err := LoadDatabase(strings.NewReader(testDatabaseXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nr, err := db.CompileNormalizers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewScanner(db, nr)
+}
+
+func TestScanBytes(t *testing.T) {
+	s := testScanner(t)
+
+	c := "<?php\n$x = 1; eval( $_POST['c']);"
+	ms := s.ScanBytes([]byte(c))
+	if len(ms) != 1 {
+		t.Fatalf("got %d matches, want 1: %v", len(ms), ms)
+	}
+	m := ms[0]
+	if m.Id != 1 || m.Title != "eval-post" || m.Type != SEVERITY_CRITICAL {
+		t.Errorf("match %+v, want signature 1", m)
+	}
+	if m.Path != "" {
+		t.Errorf("path %q, want none", m.Path)
+	}
+	if m.Time.IsZero() {
+		t.Error("the scan time is not set")
+	}
+	if m.Length <= 0 || m.Offset < 0 {
+		t.Errorf("span %d+%d", m.Offset, m.Length)
+	}
+
+	if ms := s.ScanBytes([]byte("<?php echo 'clean';")); len(ms) != 0 {
+		t.Errorf("clean content: got %v", ms)
+	}
+	if ms := s.ScanBytes(nil); len(ms) != 0 {
+		t.Errorf("empty content: got %v", ms)
+	}
+}
+
+func TestScanReader(t *testing.T) {
+	s := testScanner(t)
+
+	ms, err := s.ScanReader(strings.NewReader("<?php eval($_POST['c']); base64_decode($x);"), "stdin.php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 2 {
+		t.Fatalf("got %d matches, want 2: %v", len(ms), ms)
+	}
+	for _, m := range ms {
+		if m.Path != "stdin.php" {
+			t.Errorf("signature %d: path %q, want the name", m.Id, m.Path)
+		}
+	}
+	if ms[1].Id != 2 || ms[1].Type != SEVERITY_SOFT {
+		t.Errorf("match %+v, want signature 2", ms[1])
+	}
+}
+
+func TestScanReaderStream(t *testing.T) {
+	s := testScanner(t)
+	s.MaxSize = 1024
+
+	// The match is past the first window and straddles a block boundary
+	var b bytes.Buffer
+	b.WriteString("<?php\n")
+	for b.Len() < READER_BLOCKSIZE-64 {
+		b.WriteString("// padding\n")
+	}
+	for b.Len() < READER_BLOCKSIZE-8 {
+		b.WriteByte(' ')
+	}
+	b.WriteString("eval($_POST['c']);\n")
+	offset := strings.Index(b.String(), "$_POST")
+
+	ms, err := s.ScanReader(&b, "big.php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 1 || ms[0].Id != 1 {
+		t.Fatalf("got %v, want signature 1", ms)
+	}
+	// The span is approximate, but not off by more than the match
+	if d := ms[0].Offset - offset; d < -32 || d > 32 {
+		t.Errorf("offset %d, want about %d", ms[0].Offset, offset)
+	}
+}
+
+func TestScanFile(t *testing.T) {
+	s := testScanner(t)
+
+	path := filepath.Join(t.TempDir(), "shell.php")
+	if err := os.WriteFile(path, []byte("<?php eval($_POST['c']);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ms, err := s.ScanFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 1 || ms[0].Path != path {
+		t.Errorf("got %v, want signature 1 in %s", ms, path)
+	}
+
+	if _, err := s.ScanFile(filepath.Join(t.TempDir(), "missing.php")); !os.IsNotExist(err) {
+		t.Errorf("missing file: error %v", err)
+	}
+}
+
+func TestScannerFirstMatch(t *testing.T) {
+	s := testScanner(t)
+	s.FirstMatch = true
+
+	ms := s.ScanBytes([]byte("<?php eval($_POST['c']); base64_decode($x);"))
+	if len(ms) != 1 {
+		t.Errorf("got %d matches, want 1", len(ms))
+	}
+}
+
+func TestScannerConcurrent(t *testing.T) {
+	s := testScanner(t)
+	c := []byte("<?php eval($_POST['c']); base64_decode($x);")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if ms := s.ScanBytes(c); len(ms) != 2 {
+					t.Errorf("got %d matches, want 2", len(ms))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestScannersSideBySide(t *testing.T) {
+	a := testScanner(t)
+	b := testScanner(t)
+	b.FirstMatch = true
+
+	c := []byte("<?php eval($_POST['c']); base64_decode($x);")
+	if n := len(a.ScanBytes(c)); n != 2 {
+		t.Errorf("first scanner: %d matches, want 2", n)
+	}
+	if n := len(b.ScanBytes(c)); n != 1 {
+		t.Errorf("second scanner: %d matches, want 1", n)
+	}
+}
@@ -0,0 +1,69 @@
+package scanner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity is the level of a signature, parsed from the "sever"
+// attribute of the database. Higher levels are worse.
+type Severity int
+
+const (
+	// An unrecognized "sever" value
+	SEVERITY_UNKNOWN Severity = iota
+	SEVERITY_INFO
+	SEVERITY_SOFT
+	SEVERITY_CRITICAL
+)
+
+var severityNames = map[Severity]string{
+	SEVERITY_UNKNOWN:  "unknown",
+	SEVERITY_INFO:     "info",
+	SEVERITY_SOFT:     "soft",
+	SEVERITY_CRITICAL: "critical",
+}
+
+// Codes and names accepted in the databases and on the command line.
+var severityValues = map[string]Severity{
+	"i":        SEVERITY_INFO,
+	"info":     SEVERITY_INFO,
+	"s":        SEVERITY_SOFT,
+	"soft":     SEVERITY_SOFT,
+	"w":        SEVERITY_SOFT,
+	"warning":  SEVERITY_SOFT,
+	"c":        SEVERITY_CRITICAL,
+	"critical": SEVERITY_CRITICAL,
+	"unknown":  SEVERITY_UNKNOWN,
+}
+
+// ParseSeverity returns the severity of a one-letter code
+// or a name, e.g. "c" or "critical".
+func ParseSeverity(s string) (Severity, error) {
+	if sever, ok := severityValues[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return sever, nil
+	}
+	return SEVERITY_UNKNOWN, fmt.Errorf("unknown severity %q", s)
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return severityNames[SEVERITY_UNKNOWN]
+}
+
+// MarshalText makes the structured outputs show the severity name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText reads the severity back from the structured outputs.
+func (s *Severity) UnmarshalText(b []byte) error {
+	sever, err := ParseSeverity(string(b))
+	if err != nil {
+		return err
+	}
+	*s = sever
+	return nil
+}
@@ -0,0 +1,49 @@
+package scanner
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSeverityText(t *testing.T) {
+	for _, sever := range []Severity{SEVERITY_UNKNOWN, SEVERITY_INFO, SEVERITY_SOFT, SEVERITY_CRITICAL} {
+		b, err := json.Marshal(Match{Type: sever})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m Match
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatalf("%s: %s", b, err)
+		}
+		if m.Type != sever {
+			t.Errorf("%s: got %s, want %s", b, m.Type, sever)
+		}
+	}
+
+	tests := map[string]Severity{
+		"c":       SEVERITY_CRITICAL,
+		"Soft":    SEVERITY_SOFT,
+		"warning": SEVERITY_SOFT,
+		"i":       SEVERITY_INFO,
+		"unknown": SEVERITY_UNKNOWN,
+	}
+	for text, want := range tests {
+		sever := SEVERITY_CRITICAL
+		if err := sever.UnmarshalText([]byte(text)); err != nil {
+			t.Errorf("%q: %s", text, err)
+		}
+		if sever != want {
+			t.Errorf("%q: got %s, want %s", text, sever, want)
+		}
+	}
+
+	for _, text := range []string{"x", ""} {
+		sever := SEVERITY_CRITICAL
+		if err := sever.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("%q: no error", text)
+		}
+		if sever != SEVERITY_CRITICAL {
+			t.Errorf("%q: the severity changed to %s", text, sever)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package scanner
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Snippet returns the matched region of c with radius bytes of context
+// around it. Non-printable characters are escaped, so it is safe to print.
+// If limit is positive, the snippet is cut to at most limit bytes of c:
+// the context is shortened first, then the tail of the match.
+func Snippet(c []byte, loc []int, radius, limit int) string {
+	if limit > 0 {
+		if n := loc[1] - loc[0]; n >= limit {
+			radius, loc = 0, []int{loc[0], loc[0] + limit}
+		} else if 2*radius+n > limit {
+			radius = (limit - n) / 2
+		}
+	}
+	start, end := loc[0]-radius, loc[1]+radius
+	if start < 0 {
+		start = 0
+	}
+	if end > len(c) {
+		end = len(c)
+	}
+	// Do not cut multibyte characters, without going over the limit
+	if limit > 0 {
+		for start < end && !utf8.RuneStart(c[start]) {
+			start++
+		}
+		for end > start && end < len(c) && !utf8.RuneStart(c[end]) {
+			end--
+		}
+	} else {
+		for start > 0 && !utf8.RuneStart(c[start]) {
+			start--
+		}
+		for end < len(c) && !utf8.RuneStart(c[end]) {
+			end++
+		}
+	}
+
+	q := strings.Builder{}
+	for _, r := range string(c[start:end]) {
+		switch {
+		case r == '\n' || r == '\t':
+			q.WriteRune(r)
+		case r == utf8.RuneError || !unicode.IsPrint(r):
+			fmt.Fprintf(&q, "\\x{%x}", r)
+		default:
+			q.WriteRune(r)
+		}
+	}
+	return q.String()
+}
@@ -0,0 +1,210 @@
+package scanner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"regexp/syntax"
+	"time"
+	"unicode/utf8"
+)
+
+// READER_BLOCKSIZE is the size of the blocks a streamed file is read in.
+const READER_BLOCKSIZE = 512 * 1024 // 512K
+
+// MAX_OVERLAP limits the overlap between the windows of a streamed file.
+// It is used for the signatures whose match length is unbounded.
+const MAX_OVERLAP = 64 * 1024 // 64K
+
+// matchStream matches the content of a file that is too large to be
+// read at once. The content is read in READER_BLOCKSIZE blocks and each
+// block is matched together with the tail of the previous one, so a pattern
+// that straddles the block boundary still matches. Normalization is applied
+// to every window separately, the charset detection and the external decoder
+// are not applied at all. The offsets of the matches are approximate:
+// the span in the normalized window shifted by the window position in the file.
+func (s *Scanner) matchStream(name string, r io.Reader) ([]Match, error) {
+	var ms []Match
+	seen := make(map[int]bool)
+
+	tmpl := Match{Path: name, Time: time.Now()}
+
+	h := sha256.New()
+	var freq byteFreq
+
+	overlap := s.overlap
+	if s.Overlap > 0 {
+		overlap = s.Overlap
+	}
+
+	var size, start int64
+
+	// Number of lines before the window, counted in the raw content
+	var lines int
+	block := make([]byte, READER_BLOCKSIZE)
+	window := make([]byte, 0, overlap+READER_BLOCKSIZE)
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			h.Write(block[:n])
+			if s.Entropy {
+				freq.Write(block[:n])
+			}
+			size += int64(n)
+
+			// With FirstMatch the rest is only read for the entropy and digest
+			if s.done(seen) {
+				if !s.Entropy && !s.Hash {
+					break
+				}
+				continue
+			}
+			window = append(window, block[:n]...)
+			found := s.matchContent(tmpl, window, seen, true)
+			if s.ContextRadius > 0 {
+				locateRaw(found, window)
+			}
+			for _, m := range found {
+				ms = append(ms, shiftMatch(m, start, lines))
+			}
+			if keep := overlap; len(window) > keep {
+				if s.ContextRadius > 0 {
+					lines += bytes.Count(window[:len(window)-keep], []byte{'\n'})
+				}
+				start += int64(len(window) - keep)
+				window = window[:copy(window, window[len(window)-keep:])]
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return ms, err
+		}
+	}
+
+	// Whole-file digests are compared after the last block
+	digest := hex.EncodeToString(h.Sum(nil))
+	for i := range s.db.Signatures {
+		sig := &s.db.Signatures[i]
+		if sig.Mode != MODE_EQUALS || seen[sig.Id] || s.done(seen) || !sig.MatchName(name) {
+			continue
+		}
+		if (sig.Size == 0 || sig.Size == size) && sig.Signature == digest && !s.suppressed(sig, name) {
+			m := s.newMatch(tmpl, sig, nil, []int{0, 0})
+			m.Length = int(size)
+			ms = append(ms, m)
+			seen[sig.Id] = true
+		}
+	}
+
+	if s.Entropy {
+		e := freq.Entropy()
+		for i := range ms {
+			ms[i].Entropy = &e
+		}
+	}
+	if s.Hash {
+		for i := range ms {
+			ms[i].SHA256 = digest
+		}
+	}
+
+	return ms, nil
+}
+
+// shiftMatch moves the match found in a window to the position
+// of the window in the file, which starts at the given line.
+func shiftMatch(m Match, start int64, lines int) Match {
+	if m.Embedded != nil {
+		offset := *m.Embedded + int(start)
+		m.Embedded = &offset
+	} else {
+		m.Offset += int(start)
+		if m.Line > 0 {
+			m.Line += lines
+		}
+	}
+	if m.RawOffset != nil {
+		offset := *m.RawOffset + int(start)
+		m.RawOffset = &offset
+	}
+	return m
+}
+
+// windowOverlap returns the number of bytes kept between the windows
+// of a streamed file: the longest possible match of the signatures,
+// but no more than MAX_OVERLAP.
+func windowOverlap(db *Database) int {
+	overlap := 0
+	for _, sig := range db.Signatures {
+		if sig.Regexp == nil {
+			continue
+		}
+		re, err := syntax.Parse(sig.Signature, syntax.Perl)
+		if err != nil {
+			return MAX_OVERLAP
+		}
+		n := maxMatchLen(re)
+		if n < 0 || n > MAX_OVERLAP {
+			return MAX_OVERLAP
+		}
+		if n > overlap {
+			overlap = n
+		}
+	}
+	return overlap
+}
+
+// maxMatchLen returns the maximum length in bytes of the text
+// matched by the expression, or -1 if it is unbounded.
+func maxMatchLen(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		n := 0
+		for _, r := range re.Rune {
+			n += utf8.RuneLen(r)
+		}
+		return n
+	case syntax.OpCharClass, syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		return utf8.UTFMax
+	case syntax.OpCapture, syntax.OpQuest:
+		return maxMatchLen(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus:
+		return -1
+	case syntax.OpRepeat:
+		if re.Max < 0 {
+			return -1
+		}
+		n := maxMatchLen(re.Sub[0])
+		if n < 0 {
+			return -1
+		}
+		return n * re.Max
+	case syntax.OpConcat:
+		total := 0
+		for _, sub := range re.Sub {
+			n := maxMatchLen(sub)
+			if n < 0 {
+				return -1
+			}
+			total += n
+		}
+		return total
+	case syntax.OpAlternate:
+		max := 0
+		for _, sub := range re.Sub {
+			n := maxMatchLen(sub)
+			if n < 0 {
+				return -1
+			}
+			if n > max {
+				max = n
+			}
+		}
+		return max
+	}
+	// Empty matches and assertions
+	return 0
+}
@@ -0,0 +1,75 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// WalkFunc decides whether a file found by Walk is queued for scanning.
+// It is called for every entry below the roots and for the errors
+// of the walk with a nil info. For a directory it decides whether
+// the walk descends into it. The calls are made sequentially from
+// a single goroutine. Returning an error stops the walk.
+type WalkFunc func(path string, info os.FileInfo, err error) (bool, error)
+
+// Walk sends the paths of the files under the roots accepted by fn
+// to the returned channel. The channel is closed when all roots
+// are walked, fn stops the walk or the context is cancelled.
+func Walk(ctx context.Context, fsys FileSystem, roots []string, fn WalkFunc) <-chan string {
+	cPaths := make(chan string, 10)
+
+	// The error that stopped the walk, if any
+	var stop error
+
+	// The root being walked, it is never skipped
+	var root string
+
+	walkFn := func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil && info.IsDir() && path == root {
+			return nil
+		}
+		ok, ferr := fn(path, info, err)
+		if ferr != nil {
+			stop = ferr
+			return ferr
+		}
+		if err == nil && info.IsDir() {
+			if !ok {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+		select {
+		case cPaths <- path:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	go func() {
+		defer close(cPaths)
+		for _, root = range roots {
+			err := fsys.Walk(root, walkFn)
+			if err == nil {
+				continue
+			}
+			if ctx.Err() != nil || stop != nil {
+				return
+			}
+			// A root that cannot be walked at all
+			if _, ferr := fn(root, nil, err); ferr != nil {
+				return
+			}
+		}
+	}()
+
+	return cPaths
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// SeverityList is a list of severities given by names
+// or by the one-letter codes of the database.
+type SeverityList []scanner.Severity
+
+func (sl SeverityList) String() string {
+	parts := make([]string, 0, len(sl))
+	for _, sever := range sl {
+		parts = append(parts, sever.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (sl *SeverityList) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		if len(strings.TrimSpace(s)) == 0 {
+			continue
+		}
+		sever, err := scanner.ParseSeverity(s)
+		if err != nil {
+			return err
+		}
+		*sl = append(*sl, sever)
+	}
+	return nil
+}
+
+// ExitMap maps a severity to the process exit code
+// used when it is the worst severity matched.
+type ExitMap map[scanner.Severity]int
+
+// Process exit codes
+const (
+	// Nothing matched
+	EXIT_CLEAN = 0
+	// Something matched, the code for severities missing in the map
+	DEFAULT_MATCH_EXIT = 1
+	// The scan could not be performed or completed
+	EXIT_FATAL = 2
+)
+
+func (m ExitMap) String() string {
+	parts := make([]string, 0, len(m))
+	for k, v := range m {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *ExitMap) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid mapping %q, expected severity=code", s)
+		}
+		sever, err := scanner.ParseSeverity(kv[0])
+		if err != nil {
+			return fmt.Errorf("invalid mapping %q: %s", s, err)
+		}
+		code, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || code < 0 || code > 125 {
+			return fmt.Errorf("invalid exit code in mapping %q, expected 0..125", s)
+		}
+		(*m)[sever] = code
+	}
+	return nil
+}
+
+// Code returns the exit code for the given severity.
+func (m ExitMap) Code(sever scanner.Severity) int {
+	if code, ok := m[sever]; ok {
+		return code
+	}
+	return DEFAULT_MATCH_EXIT
+}
+
+// worstMatch tracks the highest severity matched across all workers.
+var worstMatch struct {
+	sync.Mutex
+	found bool
+	sever scanner.Severity
+}
+
+func recordSeverity(sever scanner.Severity) {
+	worstMatch.Lock()
+	defer worstMatch.Unlock()
+
+	if !worstMatch.found || sever > worstMatch.sever {
+		worstMatch.found = true
+		worstMatch.sever = sever
+	}
+}
+
+// anyMatch reports whether anything matched during the scan.
+func anyMatch() bool {
+	worstMatch.Lock()
+	defer worstMatch.Unlock()
+
+	return worstMatch.found
+}
+
+// exitCode returns the process exit code according to the worst
+// severity matched during the scan. If nothing matched, the files
+// and roots that could not be scanned make the scan incomplete,
+// so it is reported with EXIT_FATAL rather than EXIT_CLEAN. So is
+// a match the -exit-map maps to 0, which would pass for a clean scan.
+// A scan interrupted by a signal exits with EXIT_INTERRUPTED,
+// except for -watch which only stops that way.
+func exitCode() int {
+	worstMatch.Lock()
+	defer worstMatch.Unlock()
+
+	failed := atomic.LoadInt32(&rootFailed) != 0 || atomic.LoadInt64(&stats.Errors) > 0
+
+	switch {
+	case atomic.LoadInt32(&signalled) != 0 && !WATCH:
+		return EXIT_INTERRUPTED
+	case worstMatch.found:
+		if code := EXITMAP.Code(worstMatch.sever); code != EXIT_CLEAN || !failed {
+			return code
+		}
+		return EXIT_FATAL
+	case failed:
+		return EXIT_FATAL
+	}
+	return EXIT_CLEAN
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func TestExitMapSet(t *testing.T) {
+	m := make(ExitMap)
+	if err := m.Set("critical=3, s=4, unknown=5, info=0"); err != nil {
+		t.Fatal(err)
+	}
+	for sever, want := range map[scanner.Severity]int{
+		scanner.SEVERITY_CRITICAL: 3,
+		scanner.SEVERITY_SOFT:     4,
+		scanner.SEVERITY_UNKNOWN:  5,
+		scanner.SEVERITY_INFO:     0,
+	} {
+		if got := m.Code(sever); got != want {
+			t.Errorf("%s: code %d, want %d", sever, got, want)
+		}
+	}
+
+	for _, bad := range []string{"bogus=1", "critical", "critical=200"} {
+		m := make(ExitMap)
+		if err := m.Set(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	savedMap := EXITMAP
+	defer func() {
+		EXITMAP = savedMap
+		worstMatch.found = false
+		stats.Errors = 0
+		rootFailed = 0
+	}()
+	EXITMAP = ExitMap{scanner.SEVERITY_SOFT: 0, scanner.SEVERITY_CRITICAL: 3}
+
+	tests := []struct {
+		found  bool
+		sever  scanner.Severity
+		errors int64
+		root   int32
+		want   int
+	}{
+		{false, 0, 0, 0, EXIT_CLEAN},
+		{false, 0, 1, 0, EXIT_FATAL},
+		{false, 0, 0, 1, EXIT_FATAL},
+		{true, scanner.SEVERITY_CRITICAL, 0, 0, 3},
+		{true, scanner.SEVERITY_CRITICAL, 1, 0, 3},
+		{true, scanner.SEVERITY_SOFT, 0, 0, 0},
+		// A match mapped to 0 does not hide the errors
+		{true, scanner.SEVERITY_SOFT, 1, 0, EXIT_FATAL},
+		{true, scanner.SEVERITY_SOFT, 0, 1, EXIT_FATAL},
+		{true, scanner.SEVERITY_INFO, 1, 0, DEFAULT_MATCH_EXIT},
+	}
+	for _, tt := range tests {
+		worstMatch.found, worstMatch.sever = tt.found, tt.sever
+		stats.Errors, rootFailed = tt.errors, tt.root
+		if got := exitCode(); got != tt.want {
+			t.Errorf("%+v: exit code %d, want %d", tt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/0xef53/rigel/scanner"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Environment variable with the password for the SFTP authentication.
+const SFTP_PASSWORD_ENV = "RIGEL_SFTP_PASSWORD"
+
+// sftpFS is a remote file system accessed over SFTP. All workers share
+// a single SSH connection, so the number of concurrent requests
+// to the server is bounded by the number of workers.
+type sftpFS struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	prefix string
+}
+
+// dialSFTP connects to the server described by "user@host[:port]:path"
+// and returns the file system and the remote root path.
+func dialSFTP(spec string) (*sftpFS, string, error) {
+	at := strings.Index(spec, "@")
+	if at <= 0 {
+		return nil, "", fmt.Errorf("expected user@host[:port]:path, got %q", spec)
+	}
+	username := spec[:at]
+
+	parts := strings.Split(spec[at+1:], ":")
+	var host, port, root string
+	switch len(parts) {
+	case 2:
+		host, port, root = parts[0], "22", parts[1]
+	case 3:
+		host, port, root = parts[0], parts[1], parts[2]
+	default:
+		return nil, "", fmt.Errorf("expected user@host[:port]:path, got %q", spec)
+	}
+	if len(host) == 0 || len(root) == 0 {
+		return nil, "", fmt.Errorf("expected user@host[:port]:path, got %q", spec)
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !SFTPINSECURE {
+		home, _ := os.UserHomeDir()
+		cb, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot load known hosts: %s", err)
+		}
+		hostKeyCallback = cb
+	}
+
+	config := ssh.ClientConfig{
+		User:            username,
+		Auth:            sftpAuthMethods(),
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(host, port), &config)
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+
+	return &sftpFS{conn, client, username + "@" + host + ":"}, root, nil
+}
+
+// sftpAuthMethods returns the authentication methods in the order
+// they are tried: the SSH agent, the private key, the password.
+func sftpAuthMethods() []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); len(sock) > 0 {
+		if c, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(c).Signers))
+		}
+	}
+
+	keyFiles := []string{SFTPKEY}
+	if len(SFTPKEY) == 0 {
+		home, _ := os.UserHomeDir()
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			keyFiles = append(keyFiles, filepath.Join(home, ".ssh", name))
+		}
+	}
+	var signers []ssh.Signer
+	for _, path := range keyFiles {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		s, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			if len(SFTPKEY) > 0 {
+				warning(ERR_READ, err, path)
+			}
+			continue
+		}
+		signers = append(signers, s)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if pw := os.Getenv(SFTP_PASSWORD_ENV); len(pw) > 0 {
+		methods = append(methods, ssh.Password(pw))
+	}
+
+	return methods
+}
+
+func (s *sftpFS) Open(path string) (scanner.File, error) {
+	return s.client.Open(path)
+}
+
+func (s *sftpFS) Walk(root string, fn filepath.WalkFunc) error {
+	w := s.client.Walk(root)
+	for w.Step() {
+		if err := w.Err(); err != nil {
+			if err := fn(w.Path(), nil, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		switch err := fn(w.Path(), w.Stat(), nil); {
+		case err == filepath.SkipDir:
+			w.SkipDir()
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sftpFS) Name(path string) string {
+	return s.prefix + path
+}
+
+func (s *sftpFS) Close() error {
+	s.client.Close()
+	return s.conn.Close()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// SignatureIds is a list of signature ids and id ranges,
+// e.g. "12,40-45,1337".
+type SignatureIds scanner.IdRanges
+
+func (ids SignatureIds) String() string {
+	parts := make([]string, 0, len(ids))
+	for _, r := range ids {
+		parts = append(parts, r.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (ids *SignatureIds) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		bounds := strings.SplitN(s, "-", 2)
+		from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return fmt.Errorf("invalid signature id %q", s)
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || to < from {
+				return fmt.Errorf("invalid signature id range %q", s)
+			}
+		}
+		*ids = append(*ids, scanner.IdRange{From: from, To: to})
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// EXIT_INTERRUPTED is the exit code of a scan stopped by a signal,
+// 128 + SIGINT as the shells report it.
+const EXIT_INTERRUPTED = 130
+
+// errInterrupted aborts the read of a file when the scan is interrupted.
+var errInterrupted = errors.New("scan interrupted")
+
+// signalled is set when the first SIGINT or SIGTERM arrives.
+var signalled int32
+
+// handleSignals stops the scan on the first SIGINT or SIGTERM: the walker
+// stops, the files in progress are finished and the summary is printed
+// as usual. The second signal terminates the process immediately.
+func handleSignals(msg string) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		atomic.StoreInt32(&signalled, 1)
+		log.Printf("[info] %s (signal again to exit immediately)\n", msg)
+		stopScan()
+		<-sigs
+		log.Println("[info] exiting immediately")
+		os.Exit(EXIT_INTERRUPTED)
+	}()
+}
+
+// interruptibleReader fails the reads once a signal has arrived,
+// so the streamed scan of a large file stops between windows.
+type interruptibleReader struct {
+	r io.Reader
+}
+
+func (ir interruptibleReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&signalled) != 0 {
+		return 0, errInterrupted
+	}
+	return ir.r.Read(p)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// stateRecord describes a file as it was when it was last scanned.
+type stateRecord struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Matched bool      `json:"matched,omitempty"`
+}
+
+// State is the list of the files scanned by the previous run,
+// used to skip the unchanged ones. The records are only valid
+// for the database they were produced with.
+//
+// The state file has a header line with the fingerprint of the database
+// and the detection options, see stateFingerprint, followed by a JSON
+// record per line.
+type State struct {
+	Path        string
+	Fingerprint string
+
+	prev map[string]stateRecord
+
+	mu   sync.Mutex
+	next map[string]stateRecord
+}
+
+// stateFingerprint returns the digest of everything that decides whether
+// a file matches: the signatures, the normalizers, including the default
+// ones, the detection options and the suppress rules. A change of any of
+// them invalidates the records of the previous run.
+func stateFingerprint(db *scanner.Database, opts *scanner.Options, suppress SuppressRules) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "database\x00%s\x00", db.Fingerprint())
+
+	defs := db.Normalizers
+	if len(defs) == 0 {
+		defs = scanner.DefaultNormalizers
+	}
+	for _, n := range defs {
+		fmt.Fprintf(h, "normalizer\x00%s\x00%s\x00%s\x00%s\x00", n.Name, n.Action, n.Replace, n.Pattern)
+	}
+
+	fmt.Fprintf(h, "options\x00%t\x00%d\x00%t\x00%t\x00%s\x00%d\x00%d\x00",
+		opts.DetectEncoding, opts.Heuristics, opts.Decompress, opts.Embedded, opts.DecoderCmd, opts.MaxSize, opts.Overlap)
+
+	for _, r := range suppress {
+		fmt.Fprintf(h, "suppress\x00%d\x00%s\x00", r.Id, r.Glob)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// LoadState reads the state file. The previous records are ignored
+// if the file does not exist, was produced with another database
+// or other detection options, or read is false, so every file is scanned.
+func LoadState(path, fingerprint string, read bool) (*State, error) {
+	st := &State{
+		Path:        path,
+		Fingerprint: fingerprint,
+		prev:        make(map[string]stateRecord),
+		next:        make(map[string]stateRecord),
+	}
+	if !read {
+		return st, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return st, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var header struct {
+		Fingerprint string `json:"database_fingerprint"`
+	}
+	if !sc.Scan() {
+		return st, sc.Err()
+	}
+	if err := json.Unmarshal(sc.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("%s: malformed header", path)
+	}
+	if header.Fingerprint != fingerprint {
+		log.Println("[info] the database or the detection options have changed since the previous run, rescanning all files")
+		return st, nil
+	}
+
+	for n := 2; sc.Scan(); n++ {
+		var rec stateRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: malformed record", path, n)
+		}
+		st.prev[rec.Path] = rec
+	}
+	return st, sc.Err()
+}
+
+// Unchanged reports whether the file has the same size and modification
+// time as when it was scanned by the previous run without a match.
+// The record is kept for the next run then. It is called
+// from the walker only.
+func (st *State) Unchanged(path string, info os.FileInfo) bool {
+	rec, ok := st.prev[path]
+	if !ok || rec.Matched || rec.Size != info.Size() || !rec.ModTime.Equal(info.ModTime()) {
+		return false
+	}
+	st.mu.Lock()
+	st.next[path] = rec
+	st.mu.Unlock()
+	return true
+}
+
+// Record stores the file scanned by this run.
+func (st *State) Record(path string, info os.FileInfo, matched bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.next[path] = stateRecord{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Matched: matched,
+	}
+}
+
+// Save atomically replaces the state file with the records of this run.
+// If the scan stopped early, the previous records of the files it did
+// not reach are kept: they are checked against the files anyway.
+func (st *State) Save(complete bool) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if !complete {
+		for p, rec := range st.prev {
+			if _, ok := st.next[p]; !ok {
+				st.next[p] = rec
+			}
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(st.Path), ".rigel-state-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	enc.Encode(struct {
+		Fingerprint string `json:"database_fingerprint"`
+	}{st.Fingerprint})
+	for _, rec := range st.next {
+		enc.Encode(rec)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), st.Path)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func TestStateFingerprint(t *testing.T) {
+	db, err := scanner.LoadDatabase(strings.NewReader(`<?xml version="1.0"?>
+<database>
+<signature id="1" title="eval-post" sever="c">eval\s*\(\s*\$_POST</signature>
+</database>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := scanner.DefaultOptions()
+	fp := stateFingerprint(db, &base, nil)
+
+	if got := stateFingerprint(db, &base, nil); got != fp {
+		t.Error("the fingerprint is not stable")
+	}
+
+	// Options that do not change what matches keep the records
+	same := base
+	same.Entropy, same.Hash, same.ContextRadius = true, true, 100
+	if stateFingerprint(db, &same, nil) != fp {
+		t.Error("reporting options change the fingerprint")
+	}
+
+	changes := map[string]func(o *scanner.Options){
+		"decompress":      func(o *scanner.Options) { o.Decompress = true },
+		"embedded":        func(o *scanner.Options) { o.Embedded = true },
+		"heuristics":      func(o *scanner.Options) { o.Heuristics = 2 },
+		"decoder-cmd":     func(o *scanner.Options) { o.DecoderCmd = "php-deobf" },
+		"detect-encoding": func(o *scanner.Options) { o.DetectEncoding = true },
+		"max-size":        func(o *scanner.Options) { o.MaxSize = 1024 },
+	}
+	for name, change := range changes {
+		o := base
+		change(&o)
+		if stateFingerprint(db, &o, nil) == fp {
+			t.Errorf("%s does not change the fingerprint", name)
+		}
+	}
+
+	if stateFingerprint(db, &base, SuppressRules{{1, "*/vendor/*"}}) == fp {
+		t.Error("suppress rules do not change the fingerprint")
+	}
+
+	// The normalizers of the database replace the default ones
+	db.Normalizers = []scanner.NormalizerDef{{Name: "strip", Action: "strip", Pattern: `\s+`}}
+	if stateFingerprint(db, &base, nil) == fp {
+		t.Error("normalizers do not change the fingerprint")
+	}
+}
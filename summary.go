@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// Reasons for the scan to stop before all files were checked.
+const (
+	STOP_MAX_FILES   = "max-files"
+	STOP_MAX_MATCHES = "max-total-matches"
+	STOP_FAIL_FAST   = "fail-fast"
+	STOP_INTERRUPTED = "interrupted"
+)
+
+// ScanStats are the counters of a run shown in the scan summary.
+// The workers update them concurrently with the atomic operations.
+// Every file walked is counted as scanned, skipped or failed.
+type ScanStats struct {
+	Walked      int64 `json:"walked"`
+	Scanned     int64 `json:"scanned"`
+	Filtered    int64 `json:"skipped_filter"`
+	TooLarge    int64 `json:"skipped_size"`
+	Binary      int64 `json:"skipped_content_type"`
+	Empty       int64 `json:"skipped_empty"`
+	Unchanged   int64 `json:"skipped_unchanged"`
+	Allowed     int64 `json:"skipped_allowlist"`
+	Interrupted int64 `json:"skipped_interrupted"`
+	Errors      int64 `json:"errors"`
+	Bytes       int64 `json:"bytes_read"`
+	Matches     int64 `json:"matches"`
+
+	// Number of matches per severity name
+	BySeverity map[string]int64 `json:"matches_by_severity"`
+
+	Elapsed float64 `json:"elapsed_seconds"`
+
+	// Why the scan stopped early, the counters are partial then
+	StopReason string `json:"stop_reason,omitempty"`
+
+	// With -sample-rate, the rate and the number of matching files
+	// estimated for all the files walked
+	SampleRate       float64  `json:"sample_rate,omitempty"`
+	EstimatedMatched *float64 `json:"estimated_matched_files,omitempty"`
+
+	mu sync.Mutex
+}
+
+// Record counts a file by the outcome of its check.
+func (s *ScanStats) Record(r Reason) {
+	switch {
+	case r == "":
+		atomic.AddInt64(&s.Scanned, 1)
+	case r == SKIP_FILTERED:
+		atomic.AddInt64(&s.Filtered, 1)
+	case r == SKIP_TOO_LARGE:
+		atomic.AddInt64(&s.TooLarge, 1)
+	case r == SKIP_BINARY:
+		atomic.AddInt64(&s.Binary, 1)
+	case r == SKIP_EMPTY:
+		atomic.AddInt64(&s.Empty, 1)
+	case r == SKIP_UNCHANGED:
+		atomic.AddInt64(&s.Unchanged, 1)
+	case r == SKIP_ALLOWED:
+		atomic.AddInt64(&s.Allowed, 1)
+	case r == SKIP_INTERRUPTED:
+		atomic.AddInt64(&s.Interrupted, 1)
+	case strings.HasPrefix(string(r), "ERR_"):
+		atomic.AddInt64(&s.Errors, 1)
+	}
+}
+
+// RecordMatch counts a reported match by its severity.
+func (s *ScanStats) RecordMatch(sever scanner.Severity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.BySeverity == nil {
+		s.BySeverity = make(map[string]int64)
+	}
+	s.BySeverity[sever.String()]++
+}
+
+// Print writes the summary as a text block or as a single JSON object.
+// It must be called after all workers are finished.
+func (s *ScanStats) Print(w io.Writer, elapsed time.Duration, asJSON bool) error {
+	s.Elapsed = elapsed.Seconds()
+
+	if asJSON {
+		b, err := json.Marshal(struct {
+			Summary *ScanStats `json:"summary"`
+		}{s})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+
+	severities := make([]string, 0, len(s.BySeverity))
+	for sever, n := range s.BySeverity {
+		severities = append(severities, fmt.Sprintf("%s=%d", sever, n))
+	}
+	sort.Strings(severities)
+
+	type field struct {
+		name  string
+		value interface{}
+	}
+	fields := []field{
+		{"files walked", s.Walked},
+		{"files scanned", s.Scanned},
+		{"skipped by filter", s.Filtered},
+		{"skipped by size", s.TooLarge},
+		{"skipped by content type", s.Binary},
+		{"skipped as empty", s.Empty},
+		{"skipped as unchanged", s.Unchanged},
+		{"skipped by allowlist", s.Allowed},
+		{"skipped as interrupted", s.Interrupted},
+		{"errors", s.Errors},
+		{"bytes read", s.Bytes},
+		{"matches", s.Matches},
+		{"matches by severity", strings.Join(severities, ",")},
+		{"elapsed", elapsed.Round(time.Millisecond)},
+	}
+	if len(s.StopReason) > 0 {
+		fields = append(fields, field{"stopped early", s.StopReason + " (the results are partial)"})
+	}
+	if s.EstimatedMatched != nil {
+		fields = append(fields, field{"sampled", fmt.Sprintf("rate %g, about %.0f matching files estimated in total", s.SampleRate, *s.EstimatedMatched)})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(tw, "Summary:")
+	for _, f := range fields {
+		fmt.Fprintf(tw, "  %s:\t%v\n", f.name, f.value)
+	}
+	return tw.Flush()
+}
+
+// countingReader adds the number of bytes read to n.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
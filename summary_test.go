@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+func TestScanStatsStopReason(t *testing.T) {
+	var s ScanStats
+	var b bytes.Buffer
+	s.Print(&b, time.Second, false)
+	if strings.Contains(b.String(), "stopped early") {
+		t.Errorf("complete scan:\n%s", b.String())
+	}
+	b.Reset()
+	s.Print(&b, time.Second, true)
+	if strings.Contains(b.String(), "stop_reason") {
+		t.Errorf("complete scan: %s", b.String())
+	}
+
+	s.StopReason = STOP_MAX_FILES
+	b.Reset()
+	s.Print(&b, time.Second, false)
+	if !strings.Contains(b.String(), "stopped early:") || !strings.Contains(b.String(), "max-files") {
+		t.Errorf("capped scan:\n%s", b.String())
+	}
+
+	b.Reset()
+	s.Print(&b, time.Second, true)
+	var out struct {
+		Summary struct {
+			StopReason string `json:"stop_reason"`
+		} `json:"summary"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Summary.StopReason != STOP_MAX_FILES {
+		t.Errorf("capped scan: %s", b.String())
+	}
+}
+
+// total returns the number of files counted as scanned, skipped or failed.
+func (s *ScanStats) total() int64 {
+	return s.Scanned + s.Filtered + s.TooLarge + s.Binary + s.Empty + s.Unchanged + s.Allowed + s.Interrupted + s.Errors
+}
+
+func TestScanStatsRecord(t *testing.T) {
+	reasons := []Reason{"", SKIP_FILTERED, SKIP_BINARY, SKIP_TOO_LARGE, SKIP_UNCHANGED, SKIP_ALLOWED, SKIP_EMPTY, SKIP_INTERRUPTED,
+		ERR_PERMISSION, ERR_NOT_FOUND, ERR_READ, ERR_WALK, ERR_DECODER, ERR_QUARANTINE}
+	var s ScanStats
+	for _, r := range reasons {
+		s.Record(r)
+	}
+	if n := s.total(); n != int64(len(reasons)) {
+		t.Errorf("%d of %d reasons counted: %+v", n, len(reasons), &s)
+	}
+}
+
+func TestAcceptFileRecorded(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.php")
+	if err := os.WriteFile(path, []byte("<?php"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savedAfter, savedSampler, savedFs := modifiedAfter, sampler, fsys
+	defer func() { modifiedAfter, sampler, fsys = savedAfter, savedSampler, savedFs }()
+	defer func() { stats = ScanStats{} }()
+	fsys = scanner.LocalFS{}
+
+	tests := []struct {
+		name  string
+		setup func()
+	}{
+		{"newer-than", func() { modifiedAfter = time.Now().Add(time.Hour) }},
+		{"sample", func() { sampler = NewSampler(0, 1) }},
+		{"duplicate", func() {}},
+	}
+	for _, tt := range tests {
+		stats = ScanStats{}
+		modifiedAfter, sampler = time.Time{}, nil
+		tt.setup()
+		visited := map[string]struct{}{resolvePath(path): {}}
+		if ok, _ := acceptFile(&scanner.Scanner{}, path, info, visited); ok {
+			t.Errorf("%s: accepted", tt.name)
+		}
+		if stats.Walked != 1 || stats.total() != 1 {
+			t.Errorf("%s: walked %d, counted %d", tt.name, stats.Walked, stats.total())
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SuppressRule hides matches of a single signature
+// on paths matching a glob pattern.
+type SuppressRule struct {
+	Id   int
+	Glob string
+}
+
+type SuppressRules []SuppressRule
+
+// loadSuppressRules reads the rules from a file where each line
+// consists of a signature id and a glob pattern separated by whitespace:
+//
+//	# comment
+//	1337 */vendor/*/templates/*.php
+//
+// The pattern is matched against the full path and against each of its
+// trailing parts, so "uploads/*.php" matches "/var/www/site/uploads/a.php".
+func loadSuppressRules(path string) (SuppressRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules SuppressRules
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		// The pattern is the rest of the line after the first run
+		// of whitespace and may contain spaces itself
+		i := strings.IndexFunc(line, unicode.IsSpace)
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected signature id and path pattern", path, n)
+		}
+		id, err := strconv.Atoi(line[:i])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid signature id %q", path, n, line[:i])
+		}
+		glob := strings.TrimSpace(line[i:])
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q: %s", path, n, glob, err)
+		}
+		rules = append(rules, SuppressRule{id, glob})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Match reports whether a match of the signature id on path is suppressed.
+func (rr SuppressRules) Match(id int, path string) bool {
+	for _, r := range rr {
+		if r.Id != id {
+			continue
+		}
+		if matchTail(r.Glob, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTail matches the pattern against the path
+// and against each of its trailing parts.
+func matchTail(pattern, path string) bool {
+	for p := path; ; {
+		if ok, _ := filepath.Match(pattern, p); ok {
+			return true
+		}
+		i := strings.IndexRune(p, filepath.Separator)
+		if i < 0 {
+			return false
+		}
+		p = p[i+1:]
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadSuppressRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suppress")
+	content := "# comment\n" +
+		"1337 */vendor/*.php\n" +
+		"12\tuploads/*.php\n" +
+		"  40   \t  my documents/*.php  \n" +
+		"\n" +
+		"7 a b\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadSuppressRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SuppressRules{
+		{1337, "*/vendor/*.php"},
+		{12, "uploads/*.php"},
+		{40, "my documents/*.php"},
+		{7, "a b"},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("got %v, want %v", rules, want)
+	}
+
+	if !rules.Match(40, filepath.FromSlash("/home/u/my documents/x.php")) {
+		t.Error("pattern with a space does not match")
+	}
+	if rules.Match(12, filepath.FromSlash("/srv/site/images/a.php")) {
+		t.Error("pattern matches another directory")
+	}
+}
+
+func TestLoadSuppressRulesErrors(t *testing.T) {
+	for _, line := range []string{"1337", "1337\t", "abc */x.php", "12 [a-"} {
+		path := filepath.Join(t.TempDir(), "suppress")
+		if err := os.WriteFile(path, []byte(line+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadSuppressRules(path); err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+}
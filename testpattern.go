@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// testPattern matches a single signature pattern against the normalized
+// content of the input file and prints where it matches. It is meant
+// for the signature authors and does not need a database.
+func testPattern(w io.Writer, pattern, input string, nr []scanner.Normalizer) (bool, error) {
+	s := scanner.Signature{Signature: pattern}
+	if err := s.Compile(LONGEST); err != nil {
+		return false, err
+	}
+
+	c, err := ioutil.ReadFile(input)
+	if err != nil {
+		return false, err
+	}
+	n, _ := scanner.Normalize(c, nr)
+
+	loc := s.Regexp.FindIndex(n)
+	if loc == nil {
+		fmt.Fprintf(w, "No match: %s (%d bytes, %d after normalization)\n", input, len(c), len(n))
+		return false, nil
+	}
+
+	fmt.Fprintf(w, "Matched: %s [%d bytes at offset %d of normalized content]\n", input, loc[1]-loc[0], loc[0])
+	fmt.Fprintf(w, "Match: %s\n", scanner.Snippet(n, loc, 0, 0))
+	fmt.Fprintf(w, "Context:\n%s\n", scanner.Snippet(n, loc, SNIPPET_RADIUS, 0))
+
+	return true, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// firedSet records the ids of signatures that matched at least once,
+// including the suppressed matches. The list of unused signatures
+// is only meaningful after a scan of a large and representative corpus.
+type firedSet struct {
+	mu  sync.Mutex
+	ids map[int]struct{}
+}
+
+var firedSignatures = firedSet{ids: make(map[int]struct{})}
+
+func (fs *firedSet) Add(id int) {
+	fs.mu.Lock()
+	fs.ids[id] = struct{}{}
+	fs.mu.Unlock()
+}
+
+// PrintUnused writes the signatures that never matched.
+func (fs *firedSet) PrintUnused(w io.Writer, sigs []scanner.Signature) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var unused []scanner.Signature
+	for _, s := range sigs {
+		if _, ok := fs.ids[s.Id]; !ok {
+			unused = append(unused, s)
+		}
+	}
+
+	fmt.Fprintf(w, "Signatures that never matched (%d of %d):\n", len(unused), len(sigs))
+	for _, s := range unused {
+		fmt.Fprintf(w, "  %8d  %s\n", s.Id, s.Title)
+	}
+}
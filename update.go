@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// updateDatabase downloads the database from url and stores it at dst.
+// The content is fully decoded and all signatures are compiled before
+// the local copy is replaced, so a broken download never overwrites
+// a working database. Returns the number of signatures in the new copy.
+func updateDatabase(url, dst string) (int, error) {
+	if !scanner.IsRemote(url) {
+		return 0, fmt.Errorf("not an http(s) link: %s", url)
+	}
+	if scanner.IsRemote(dst) {
+		return 0, fmt.Errorf("database path must be a local file: %s", dst)
+	}
+
+	b, err := scanner.FetchDatabase(url, fetchOpts)
+	if err != nil {
+		return 0, err
+	}
+	db, err := scanner.DecodeDatabase(b)
+	if err != nil {
+		return 0, fmt.Errorf("invalid database: %s", err)
+	}
+	if len(db.Signatures) == 0 {
+		return 0, fmt.Errorf("no signatures loaded, check file format")
+	}
+	for i := range db.Signatures {
+		if err := db.Signatures[i].Compile(LONGEST); err != nil {
+			return 0, err
+		}
+	}
+
+	// Replace the local copy atomically
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".rigel-db-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return 0, err
+	}
+
+	return len(db.Signatures), nil
+}
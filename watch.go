@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xef53/rigel/scanner"
+)
+
+// WATCH_DEBOUNCE is how long a file has to stay unchanged
+// before it is scanned, so a file being uploaded is scanned once.
+const WATCH_DEBOUNCE = time.Second
+
+// watch watches the directory trees of the roots and sends the paths
+// of the files created or modified there to the returned channel, once
+// they stop changing. The files pass the same filters as in the walk.
+// The watches are set up before watch returns, so the changes made
+// during the initial scan are not missed. The channel is closed
+// when the context is cancelled.
+func watch(ctx context.Context, scn *scanner.Scanner, roots []string) (<-chan string, error) {
+	w, err := newWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// Adds the watches for the directory tree, the files found there
+	// are reported too since they may have been created before
+	// the watch of a new directory was added
+	addTree := func(dir string, found func(string)) error {
+		return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// The directory may be gone already
+				return nil
+			}
+			if !info.IsDir() {
+				if found != nil {
+					found(path)
+				}
+				return nil
+			}
+			if path != dir && !watchDir(roots, path) {
+				return filepath.SkipDir
+			}
+			return w.Add(path)
+		})
+	}
+	for _, r := range roots {
+		if err := addTree(r, nil); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	var mu sync.Mutex
+	pending := make(map[string]time.Time)
+	touch := func(path string) {
+		mu.Lock()
+		pending[path] = time.Now()
+		mu.Unlock()
+	}
+
+	go func() {
+		err := w.Read(func(path string, dir bool) {
+			if !dir {
+				touch(path)
+				return
+			}
+			if watchDir(roots, path) {
+				if err := addTree(path, touch); err != nil {
+					log.Printf("[warning] cannot watch %s: %s\n", path, err)
+				}
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Println("[warning] watch error:", err)
+		}
+	}()
+
+	cPaths := make(chan string, 10)
+
+	go func() {
+		defer close(cPaths)
+		defer w.Close()
+
+		t := time.NewTicker(WATCH_DEBOUNCE / 4)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-t.C:
+				var ready []string
+				mu.Lock()
+				for p, changed := range pending {
+					if now.Sub(changed) >= WATCH_DEBOUNCE {
+						ready = append(ready, p)
+						delete(pending, p)
+					}
+				}
+				mu.Unlock()
+
+				for _, p := range ready {
+					info, err := os.Stat(p)
+					if err != nil || !info.Mode().IsRegular() {
+						continue
+					}
+					if EXCLUDE.Match(rootOf(roots, p), p, false) {
+						atomic.AddInt64(&excludedPaths, 1)
+						continue
+					}
+					if ok, _ := acceptFile(scn, p, info, nil); !ok {
+						continue
+					}
+					select {
+					case cPaths <- p:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return cPaths, nil
+}
+
+// watchDir reports whether the changes in the directory found
+// under the roots are watched.
+func watchDir(roots []string, path string) bool {
+	if EXCLUDE.Match(rootOf(roots, path), path, true) {
+		return false
+	}
+	return quarantine == nil || !quarantine.IsDir(path)
+}
+
+// chainPaths returns a channel that yields the paths of a,
+// then the paths of b.
+func chainPaths(a, b <-chan string) <-chan string {
+	c := make(chan string)
+	go func() {
+		defer close(c)
+		for p := range a {
+			c <- p
+		}
+		for p := range b {
+			c <- p
+		}
+	}()
+	return c
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// fsWatcher is only implemented on Linux.
+type fsWatcher struct{}
+
+func newWatcher() (*fsWatcher, error) {
+	return nil, errors.New("watching for changes is only supported on Linux")
+}
+
+func (w *fsWatcher) Add(dir string) error { return nil }
+
+func (w *fsWatcher) Read(fn func(path string, dir bool)) error { return nil }
+
+func (w *fsWatcher) Close() error { return nil }
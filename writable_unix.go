@@ -0,0 +1,95 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// WritableFilter selects files that can be modified by a given account,
+// i.e. the actual attack surface of a web server. Only the permission
+// bits and the ownership of the file itself are taken into account.
+type WritableFilter struct {
+	uid  uint32
+	gids map[uint32]struct{}
+}
+
+// newWritableFilter parses the "user[:group]" specification. The user's
+// primary and supplementary groups are always included.
+func newWritableFilter(spec string) (*WritableFilter, error) {
+	parts := strings.SplitN(spec, ":", 2)
+
+	u, err := lookupUser(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported uid %q", u.Uid)
+	}
+
+	wf := WritableFilter{
+		uid:  uint32(uid),
+		gids: make(map[uint32]struct{}),
+	}
+
+	gids, err := u.GroupIds()
+	if err != nil {
+		gids = []string{u.Gid}
+	}
+	if len(parts) == 2 && len(parts[1]) > 0 {
+		g, err := lookupGroup(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		gids = append(gids, g.Gid)
+	}
+	for _, s := range gids {
+		if gid, err := strconv.ParseUint(s, 10, 32); err == nil {
+			wf.gids[uint32(gid)] = struct{}{}
+		}
+	}
+
+	return &wf, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupGroupId(name)
+	}
+	return user.LookupGroup(name)
+}
+
+// Allow reports whether the file is writable by the account.
+func (wf *WritableFilter) Allow(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	mode := info.Mode().Perm()
+
+	switch {
+	case wf.uid == 0:
+		return true
+	case mode&0002 != 0:
+		return true
+	case st.Uid == wf.uid && mode&0200 != 0:
+		return true
+	}
+	if _, ok := wf.gids[st.Gid]; ok && mode&0020 != 0 {
+		return true
+	}
+	return false
+}
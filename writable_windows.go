@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type WritableFilter struct{}
+
+func newWritableFilter(spec string) (*WritableFilter, error) {
+	return nil, fmt.Errorf("not supported on this platform")
+}
+
+func (wf *WritableFilter) Allow(info os.FileInfo) bool {
+	return true
+}